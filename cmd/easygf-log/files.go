@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/easygf/core/log/parse"
+)
+
+func sortLogFiles(files []parse.FileName) {
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].Less(files[j])
+	})
+}
+
+// listFiles expands the files, directories and globs of args into log files in time order
+func listFiles(args []string) ([]parse.FileName, error) {
+	var files []parse.FileName
+	for _, arg := range args {
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			matches = []string{arg}
+		}
+		for _, p := range matches {
+			st, err := os.Stat(p)
+			if err != nil {
+				return nil, err
+			}
+			if !st.IsDir() {
+				f, _ := parse.ParseFileName(p)
+				files = append(files, f)
+				continue
+			}
+			entries, err := os.ReadDir(p)
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range entries {
+				if e.IsDir() {
+					continue
+				}
+				if f, ok := parse.ParseFileName(filepath.Join(p, e.Name())); ok {
+					files = append(files, f)
+				}
+			}
+		}
+	}
+	sortLogFiles(files)
+	return files, nil
+}
+
+func openLogFile(p string) (io.ReadCloser, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(p, ".gz") {
+		return f, nil
+	}
+	z, err := gzip.NewReader(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &gzipFile{Reader: z, f: f}, nil
+}
+
+type gzipFile struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func (g *gzipFile) Close() error {
+	_ = g.Reader.Close()
+	return g.f.Close()
+}
+
+func scanFile(f parse.FileName, fn func(line string, year int)) error {
+	r, err := openLogFile(f.Path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+	year := f.Year()
+	br := bufio.NewReaderSize(r, 64*1024)
+	for {
+		line, err := br.ReadString('\n')
+		if len(line) > 0 {
+			fn(strings.TrimRight(line, "\r\n"), year)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// latestFile returns the newest uncompressed file of the logger dir/name
+func latestFile(dir, name string) (parse.FileName, bool) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return parse.FileName{}, false
+	}
+	var files []parse.FileName
+	for _, e := range entries {
+		f, ok := parse.ParseFileName(filepath.Join(dir, e.Name()))
+		if ok && f.Name == name && !f.Compressed {
+			files = append(files, f)
+		}
+	}
+	if len(files) == 0 {
+		return parse.FileName{}, false
+	}
+	sortLogFiles(files)
+	return files[len(files)-1], true
+}
+
+const followPoll = 200 * time.Millisecond
+
+// followLogger prints the lines appended to the logger of target, a log file or
+// dir/name, moving to the next file when the hour or the segment changes
+func followLogger(target string, fn func(line string, year int)) error {
+	dir, name := filepath.Split(target)
+	if f, ok := parse.ParseFileName(target); ok {
+		name = f.Name
+	}
+	if dir == "" {
+		dir = "."
+	}
+	var cur parse.FileName
+	var r *os.File
+	var br *bufio.Reader
+	var partial string
+	for {
+		if r == nil {
+			f, ok := latestFile(dir, name)
+			if !ok {
+				time.Sleep(followPoll)
+				continue
+			}
+			fp, err := os.Open(f.Path)
+			if err != nil {
+				return err
+			}
+			if cur.Path == "" {
+				// start at the end like tail -f
+				_, err = fp.Seek(0, io.SeekEnd)
+				if err != nil {
+					_ = fp.Close()
+					return err
+				}
+			}
+			cur, r, br = f, fp, bufio.NewReaderSize(fp, 64*1024)
+		}
+		line, err := br.ReadString('\n')
+		if err == nil {
+			fn(strings.TrimRight(partial+line, "\r\n"), cur.Year())
+			partial = ""
+			continue
+		}
+		partial += line
+		if err != io.EOF {
+			return err
+		}
+		next, ok := latestFile(dir, name)
+		if ok && next.Path != cur.Path {
+			// drained the old file, the writer has moved on
+			if partial != "" {
+				fn(partial, cur.Year())
+				partial = ""
+			}
+			_ = r.Close()
+			r = nil
+			continue
+		}
+		time.Sleep(followPoll)
+	}
+}
@@ -0,0 +1,196 @@
+// Command easygf-log filters the hourly text logs of the log package, e.g.
+//
+//	easygf-log -level warn -since 1h -caller 'config/' /home/brick/log
+//	easygf-log -reqid 5f3a -f /home/brick/log/myapp
+//
+// Lines not starting a record, e.g. the rest of a multi-line message, go with
+// the record before them.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/easygf/core/log"
+	"github.com/easygf/core/log/parse"
+)
+
+type filter struct {
+	level    log.Level
+	hasLevel bool
+	since    time.Time
+	until    time.Time
+	reqid    string
+	gid      int64
+	caller   *regexp.Regexp
+}
+
+func (f *filter) match(e *log.Record) bool {
+	if f.hasLevel && e.Level < f.level {
+		return false
+	}
+	if !f.since.IsZero() && e.Time.Before(f.since) {
+		return false
+	}
+	if !f.until.IsZero() && !e.Time.Before(f.until) {
+		return false
+	}
+	if f.reqid != "" && e.Ctx != f.reqid {
+		return false
+	}
+	if f.gid != 0 && e.Gid != f.gid {
+		return false
+	}
+	if f.caller != nil && !f.caller.MatchString(e.Caller()+":"+e.Func) {
+		return false
+	}
+	return true
+}
+
+var timeLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// parseTime accepts a layout of timeLayouts, or a duration meaning that long ago
+func parseTime(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	for _, layout := range timeLayouts {
+		t, err := time.ParseInLocation(layout, s, time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
+
+type printer struct {
+	f       *filter
+	color   bool
+	w       *bufio.Writer
+	matched bool
+	flush   bool
+}
+
+func (p *printer) line(raw string, year int) {
+	if r, ok := parse.Line(raw, year); ok {
+		p.matched = p.f.match(r)
+	}
+	if !p.matched {
+		return
+	}
+	s := raw
+	if !p.color {
+		s = parse.StripColor(raw)
+	}
+	_, _ = p.w.WriteString(s)
+	_ = p.w.WriteByte('\n')
+	if p.flush {
+		_ = p.w.Flush()
+	}
+}
+
+func main() {
+	var (
+		level  = flag.String("level", "", "lowest level shown, e.g. warn or WAR")
+		since  = flag.String("since", "", "first time shown, 2006-01-02T15:04:05 or a duration like 30m")
+		until  = flag.String("until", "", "time shown up to, excluded, same forms as -since")
+		reqid  = flag.String("reqid", "", "request id")
+		gid    = flag.Int64("gid", 0, "goroutine id")
+		caller = flag.String("caller", "", "regexp matched against pkg/file.go:line:func")
+		color  = flag.Bool("color", false, "keep colour codes")
+		follow = flag.Bool("f", false, "follow the logger of a log file or dir/name across rollovers")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] file|dir|glob...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	f := &filter{reqid: *reqid, gid: *gid}
+	var err error
+	if *level != "" {
+		f.level, err = parse.ParseLevel(*level)
+		if err != nil {
+			fatal(err)
+		}
+		f.hasLevel = true
+	}
+	if *since != "" {
+		f.since, err = parseTime(*since)
+		if err != nil {
+			fatal(err)
+		}
+	}
+	if *until != "" {
+		f.until, err = parseTime(*until)
+		if err != nil {
+			fatal(err)
+		}
+	}
+	if *caller != "" {
+		f.caller, err = regexp.Compile(*caller)
+		if err != nil {
+			fatal(err)
+		}
+	}
+
+	w := bufio.NewWriterSize(os.Stdout, 64*1024)
+	defer func() {
+		_ = w.Flush()
+	}()
+	p := &printer{f: f, color: *color, w: w, flush: *follow}
+
+	args := flag.Args()
+	if *follow {
+		if len(args) != 1 {
+			fatal(fmt.Errorf("-f takes one log file or dir/name"))
+		}
+		err = followLogger(args[0], p.line)
+		if err != nil {
+			fatal(err)
+		}
+		return
+	}
+	if len(args) == 0 {
+		err = scanStdin(p.line)
+		if err != nil {
+			fatal(err)
+		}
+		return
+	}
+	files, err := listFiles(args)
+	if err != nil {
+		fatal(err)
+	}
+	for _, lf := range files {
+		err = scanFile(lf, p.line)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "read %s fail, err %s\n", lf.Path, err)
+		}
+	}
+}
+
+func scanStdin(fn func(line string, year int)) error {
+	year := time.Now().Year()
+	sc := bufio.NewScanner(os.Stdin)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		fn(sc.Text(), year)
+	}
+	return sc.Err()
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+	os.Exit(2)
+}
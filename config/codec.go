@@ -0,0 +1,363 @@
+package config
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/easygf/core/etcdclient"
+	"github.com/easygf/core/etcdutils"
+	"github.com/easygf/core/json"
+	"github.com/easygf/core/log"
+)
+
+// values stored in etcd may carry one of these headers, plain values never
+// start with a NUL byte so they are read back unchanged. The gzip body is
+// base64 encoded, stored values stay valid UTF-8 for the local JSON copy and
+// for text readers like etcdctl.
+const (
+	gzipHeader     = "\x00egz1\x00"
+	manifestHeader = "\x00ech1\x00"
+)
+
+// chunks live outside Prefix so that listing the config namespace never sees
+// them, under chunk_<key>/<gen>/<i> with the key in unpadded base64url which
+// has no '/', so the chunks of a/b never fall under the prefix of a
+const chunkPrefix = "chunk_"
+
+var (
+	// values at least this long are gzipped when it makes them smaller
+	CompressThreshold = 64 * 1024
+	// larger values are split into chunks of this size, below etcd's 1.5 MiB request limit
+	MaxValueSize = 1024 * 1024
+)
+
+var (
+	ErrChunkCorrupt = errors.New("config chunks corrupt")
+	ErrLocalChunked = errors.New("local copy holds a chunk manifest instead of the value")
+)
+
+type chunkManifest struct {
+	Gen  string `json:"gen"`
+	N    int    `json:"n"`
+	Size int    `json:"size"`
+	Crc  uint32 `json:"crc"`
+}
+
+func chunkKeyPrefix(realKey string) string {
+	return chunkPrefix + base64.RawURLEncoding.EncodeToString([]byte(realKey)) + "/"
+}
+
+func chunkGenPrefix(realKey, gen string) string {
+	return chunkKeyPrefix(realKey) + gen + "/"
+}
+
+func chunkKey(realKey, gen string, i int) string {
+	// zero padded so that key order is chunk order
+	return fmt.Sprintf("%s%06d", chunkGenPrefix(realKey, gen), i)
+}
+
+func compressValue(val string) string {
+	if len(val) < CompressThreshold {
+		return val
+	}
+	var b bytes.Buffer
+	b.WriteString(gzipHeader)
+	enc := base64.NewEncoder(base64.StdEncoding, &b)
+	w := gzip.NewWriter(enc)
+	_, err := w.Write([]byte(val))
+	if err == nil {
+		err = w.Close()
+	}
+	if err == nil {
+		err = enc.Close()
+	}
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return val
+	}
+	if b.Len() >= len(val) {
+		return val
+	}
+	return b.String()
+}
+
+func decompressValue(val string) (string, error) {
+	if !strings.HasPrefix(val, gzipHeader) {
+		return val, nil
+	}
+	r, err := gzip.NewReader(base64.NewDecoder(base64.StdEncoding, strings.NewReader(val[len(gzipHeader):])))
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// putValue stores val under realKey, compressing and chunking it as needed.
+// ver < 0 writes unconditionally, otherwise the key version must match.
+// Chunks are written under a fresh generation first, one request each since a
+// transaction holding them all would exceed the very request limit that made
+// the value chunked. They become visible only when the manifest is swapped in,
+// together with the removal of the previous generations, in a single
+// transaction, so readers and watchers see exactly one logical update. A write
+// failing halfway drops its generation, whatever that leaves behind goes with
+// the next write of the key or with Config.ReapChunks.
+func putValue(cli *clientv3.Client, realKey, val string, ver int64, timeout time.Duration) (bool, error) {
+	payload := compressValue(val)
+	if len(payload) <= MaxValueSize {
+		ops := []clientv3.Op{clientv3.OpPut(realKey, payload), clientv3.OpDelete(chunkKeyPrefix(realKey), clientv3.WithPrefix())}
+		var cmps []clientv3.Cmp
+		if ver >= 0 {
+			cmps = append(cmps, clientv3.Compare(clientv3.Version(realKey), "=", ver))
+		}
+		return etcdutils.Commit(cli, cmps, ops, timeout)
+	}
+	for i := 1; ; i++ {
+		ok, err := putChunks(cli, realKey, payload, ver, timeout)
+		if err != nil || ok || ver >= 0 {
+			return ok, err
+		}
+		// last writer wins like a plain put, the chunks of the other writer
+		// may be gone with its manifest so they are all written again
+		if i >= chunkPutAttempts {
+			return false, fmt.Errorf("key %s updated concurrently %d times", realKey, i)
+		}
+		log.Warnf("key %s updated concurrently, retry", realKey)
+	}
+}
+
+// unconditional chunked writes losing the race to the manifest are retried this often
+const chunkPutAttempts = 3
+
+// putChunks writes payload as a new chunk generation and swaps in its
+// manifest if the key version is still ver, or for ver < 0 still the one
+// seen before the swap
+func putChunks(cli *clientv3.Client, realKey, payload string, ver int64, timeout time.Duration) (bool, error) {
+	m := chunkManifest{
+		Gen:  strconv.FormatInt(time.Now().UnixNano(), 36),
+		Size: len(payload),
+		Crc:  crc32.ChecksumIEEE([]byte(payload)),
+	}
+	for off, end := 0, 0; off < len(payload); off = end {
+		end = off + MaxValueSize
+		if end > len(payload) {
+			end = len(payload)
+		}
+		// never split a rune, each chunk stays valid UTF-8
+		for end < len(payload) && end > off+1 && !utf8.RuneStart(payload[end]) {
+			end--
+		}
+		err := etcdutils.Set(cli, chunkKey(realKey, m.Gen, m.N), payload[off:end], timeout)
+		if err != nil {
+			dropChunkGen(cli, realKey, m.Gen, timeout)
+			return false, err
+		}
+		m.N++
+	}
+	j, err := json.Marshal(&m)
+	if err != nil {
+		dropChunkGen(cli, realKey, m.Gen, timeout)
+		return false, err
+	}
+	if ver < 0 {
+		// pin the current version so the previous generation we drop is really the previous one
+		_, ver, err = etcdutils.GetWithVersion(cli, realKey, timeout)
+		if err != nil {
+			dropChunkGen(cli, realKey, m.Gen, timeout)
+			return false, err
+		}
+	}
+	cmps := []clientv3.Cmp{clientv3.Compare(clientv3.Version(realKey), "=", ver)}
+	ops := []clientv3.Op{clientv3.OpPut(realKey, manifestHeader+string(j))}
+	prev, err := listChunkGens(cli, realKey, timeout)
+	if err != nil {
+		dropChunkGen(cli, realKey, m.Gen, timeout)
+		return false, err
+	}
+	for _, gen := range prev {
+		if gen != m.Gen {
+			ops = append(ops, clientv3.OpDelete(chunkGenPrefix(realKey, gen), clientv3.WithPrefix()))
+		}
+	}
+	ok, err := etcdutils.Commit(cli, cmps, ops, timeout)
+	if err != nil || !ok {
+		dropChunkGen(cli, realKey, m.Gen, timeout)
+	}
+	return ok, err
+}
+
+func listChunkGens(cli *clientv3.Client, realKey string, timeout time.Duration) ([]string, error) {
+	keys, err := etcdutils.ListKeysWithPrefix(cli, chunkKeyPrefix(realKey), nil, timeout)
+	if err != nil {
+		return nil, err
+	}
+	var gens []string
+	for _, k := range keys {
+		gen := k[len(chunkKeyPrefix(realKey)):]
+		if idx := strings.Index(gen, "/"); idx >= 0 {
+			gen = gen[:idx]
+		}
+		if len(gens) == 0 || gens[len(gens)-1] != gen {
+			gens = append(gens, gen)
+		}
+	}
+	return gens, nil
+}
+
+func dropChunkGen(cli *clientv3.Client, realKey, gen string, timeout time.Duration) {
+	err := etcdutils.DelWithPrefix(cli, chunkGenPrefix(realKey, gen), timeout)
+	if err != nil {
+		log.Errorf("drop chunks of %s gen %s err:%v", realKey, gen, err)
+	}
+}
+
+// chunk generations younger than this may belong to a write still in progress
+const chunkGenGrace = 10 * time.Minute
+
+// genTime is when gen was written, zero for names putValue never makes
+func genTime(gen string) time.Time {
+	n, err := strconv.ParseInt(gen, 36, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+// reapChunks deletes the chunk generations older than chunkGenGrace which the
+// manifest of their key does not refer to, it returns how many went
+func reapChunks(cli *clientv3.Client, timeout time.Duration) (int, error) {
+	keys, err := etcdutils.ListKeysWithPrefix(cli, chunkPrefix, nil, timeout)
+	if err != nil {
+		return 0, err
+	}
+	// chunk_<key>/<gen>/<i> in key order, the gens of a key are adjacent
+	gens := map[string][]string{}
+	var encKeys []string
+	for _, k := range keys {
+		parts := strings.SplitN(k[len(chunkPrefix):], "/", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		list := gens[parts[0]]
+		if len(list) == 0 {
+			encKeys = append(encKeys, parts[0])
+		}
+		if len(list) == 0 || list[len(list)-1] != parts[1] {
+			gens[parts[0]] = append(list, parts[1])
+		}
+	}
+	n := 0
+	for _, enc := range encKeys {
+		b, err := base64.RawURLEncoding.DecodeString(enc)
+		if err != nil {
+			log.Errorf("chunk key %s err:%v", enc, err)
+			continue
+		}
+		realKey := string(b)
+		val, ver, err := etcdutils.GetWithVersion(cli, realKey, timeout)
+		if err != nil {
+			return n, err
+		}
+		var m chunkManifest
+		if strings.HasPrefix(val, manifestHeader) {
+			err = json.Unmarshal([]byte(val[len(manifestHeader):]), &m)
+			if err != nil {
+				log.Errorf("manifest of %s err:%v", realKey, err)
+				continue
+			}
+		}
+		var ops []clientv3.Op
+		for _, gen := range gens[enc] {
+			if gen != m.Gen && time.Since(genTime(gen)) > chunkGenGrace {
+				ops = append(ops, clientv3.OpDelete(chunkGenPrefix(realKey, gen), clientv3.WithPrefix()))
+			}
+		}
+		if len(ops) == 0 {
+			continue
+		}
+		// a manifest swapped in meanwhile may refer to one of them
+		ok, err := etcdutils.Commit(cli, []clientv3.Cmp{clientv3.Compare(clientv3.Version(realKey), "=", ver)}, ops, timeout)
+		if err != nil {
+			return n, err
+		}
+		if ok {
+			log.Infof("reaped %d chunk generations of %s", len(ops), realKey)
+			n += len(ops)
+		}
+	}
+	return n, nil
+}
+
+func delValue(cli *clientv3.Client, realKey string, timeout time.Duration) error {
+	_, err := etcdutils.Commit(cli, nil, []clientv3.Op{
+		clientv3.OpDelete(realKey),
+		clientv3.OpDelete(chunkKeyPrefix(realKey), clientv3.WithPrefix()),
+	}, timeout)
+	return err
+}
+
+// decodeValue turns a stored value back into the logical one, chunks are
+// fetched with cli as of rev, the revision val was read at, so a concurrent
+// rewrite dropping the generation cannot break the read. It is the revision
+// of the read, not the ModRevision of the manifest, which compaction may
+// already have discarded. The local copy is read with a nil cli, it must hold
+// the reassembled value, a manifest there fails with ErrLocalChunked rather
+// than reaching out to etcd from the local path.
+func decodeValue(cli *clientv3.Client, realKey, val string, rev int64) (string, error) {
+	if strings.HasPrefix(val, manifestHeader) {
+		if cli == nil {
+			return "", ErrLocalChunked
+		}
+		var err error
+		val, err = readChunks(cli, realKey, val[len(manifestHeader):], rev)
+		if err != nil {
+			return "", err
+		}
+	}
+	return decompressValue(val)
+}
+
+func readChunks(cli *clientv3.Client, realKey, manifest string, rev int64) (string, error) {
+	var m chunkManifest
+	err := json.Unmarshal([]byte(manifest), &m)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	b.Grow(m.Size)
+	n := 0
+	it := etcdutils.NewPrefixIterator(
+		cli, chunkGenPrefix(realKey, m.Gen), &etcdutils.ListOpt{PageSize: 1, Rev: rev},
+		etcdclient.GetEtcdConfig().GetOpTimeout())
+	for it.Next() {
+		for _, kv := range it.Page() {
+			b.WriteString(kv.Val)
+			n++
+		}
+	}
+	if it.Err() != nil {
+		return "", it.Err()
+	}
+	val := b.String()
+	if n != m.N || len(val) != m.Size || crc32.ChecksumIEEE([]byte(val)) != m.Crc {
+		log.Errorf("key %s gen %s chunks %d/%d size %d/%d", realKey, m.Gen, n, m.N, len(val), m.Size)
+		return "", ErrChunkCorrupt
+	}
+	return val, nil
+}
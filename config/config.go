@@ -1,6 +1,7 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"github.com/coreos/etcd/clientv3"
 	"github.com/easygf/core/etcdclient"
@@ -29,8 +30,54 @@ func GetFilePathByKey(key string) string {
 	return filepath.Join(FsPath, key+".json")
 }
 
+type ReadPolicy int
+
+const (
+	// ReadDefault inherits the policy of the Config, which itself defaults to ReadLocalFirst
+	ReadDefault ReadPolicy = iota
+	ReadLocalFirst
+	ReadRemoteFirst
+	ReadRemoteOnly
+	ReadLocalOnly
+)
+
+func (r ReadPolicy) String() string {
+	switch r {
+	case ReadLocalFirst:
+		return "local_first"
+	case ReadRemoteFirst:
+		return "remote_first"
+	case ReadRemoteOnly:
+		return "remote_only"
+	case ReadLocalOnly:
+		return "local_only"
+	default:
+		return "default"
+	}
+}
+
+// GetOpt overrides the read settings of a Config for a single call,
+// zero fields inherit the Config values
+type GetOpt struct {
+	Policy ReadPolicy
+	// local copies older than this are ignored, 0 means no bound
+	MaxStaleness time.Duration
+}
+
+var (
+	ErrLocalMiss  = errors.New("local copy not found")
+	ErrLocalStale = errors.New("local copy too stale")
+)
+
 type Config struct {
 	cli *clientv3.Client
+
+	ReadPolicy        ReadPolicy
+	MaxLocalStaleness time.Duration
+	// StaleIfCircuitOpen serves the local copy whatever its age while the etcd
+	// circuit breaker is open, overriding MaxLocalStaleness and
+	// GetOpt.MaxStaleness. Off, such reads fail with the etcd error.
+	StaleIfCircuitOpen bool
 }
 
 func NewConfig() *Config {
@@ -66,8 +113,9 @@ func (p *Config) CloseIgnoreError() {
 	_ = p.Close()
 }
 
-func (p *Config) ListByPrefix(bizPrefix string, timeout time.Duration) ([]*Item, error) {
-	err := p.EnsureConnected()
+func (p *Config) ListByPrefix(bizPrefix string, timeout time.Duration) (out []*Item, err error) {
+	defer observeOp("list", time.Now(), &err)
+	err = p.EnsureConnected()
 	if err != nil {
 		log.Errorf("err:%v", err)
 		return nil, err
@@ -81,35 +129,152 @@ func (p *Config) ListByPrefix(bizPrefix string, timeout time.Duration) ([]*Item,
 		log.Errorf("err:%v", err)
 		return nil, err
 	}
-	var out []*Item
 	for _, kv := range list {
-		var key string
-		if strings.HasPrefix(kv.Key, Prefix) {
-			key = kv.Key[len(Prefix):]
-		} else {
-			key = kv.Key
+		item, err := p.kv2Item(kv)
+		if err != nil {
+			return nil, err
 		}
-		out = append(out, &Item{
-			Key: key,
-			Val: kv.Val,
-			Ver: kv.Ver,
-		})
+		out = append(out, item)
 	}
 	return out, nil
 }
 
-func (p *Config) Set(key string, val string) error {
-	err := p.EnsureConnected()
+// IterByPrefix feeds the items under bizPrefix to cb page by page in key order,
+// timeout applies to each page. opt.SortBy must be SortByKey, other orders
+// fail with etcdutils.ErrIterSortBy, ListKeysByPrefix takes them
+func (p *Config) IterByPrefix(
+	bizPrefix string, opt *etcdutils.ListOpt, timeout time.Duration,
+	cb func(items []*Item) (stop bool)) (err error) {
+	defer observeOp("iter", time.Now(), &err)
+	err = p.EnsureConnected()
 	if err != nil {
 		log.Errorf("err:%v", err)
 		return err
 	}
-	err = etcdutils.Set(p.cli, Prefix+key, val, 3*time.Second)
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	it := etcdutils.NewPrefixIterator(p.cli, Prefix+bizPrefix, opt, timeout)
+	for it.Next() {
+		var items []*Item
+		for _, kv := range it.Page() {
+			item, err := p.kv2Item(kv)
+			if err != nil {
+				return err
+			}
+			items = append(items, item)
+		}
+		if cb(items) {
+			return nil
+		}
+	}
+	err = it.Err()
 	if err != nil {
 		log.Errorf("err:%v", err)
 		return err
 	}
-	log.Infof("set %s to %s", key, val)
+	return nil
+}
+
+func (p *Config) ListKeysByPrefix(
+	bizPrefix string, opt *etcdutils.ListOpt, timeout time.Duration) (keys []string, err error) {
+	defer observeOp("list_keys", time.Now(), &err)
+	err = p.EnsureConnected()
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return nil, err
+	}
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	keys, err = etcdutils.ListKeysWithPrefix(p.cli, Prefix+bizPrefix, opt, timeout)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return nil, err
+	}
+	for i, k := range keys {
+		keys[i] = strings.TrimPrefix(k, Prefix)
+	}
+	return keys, nil
+}
+
+func (p *Config) CountByPrefix(bizPrefix string, timeout time.Duration) (n int64, err error) {
+	defer observeOp("count", time.Now(), &err)
+	err = p.EnsureConnected()
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return 0, err
+	}
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	n, err = etcdutils.CountWithPrefix(p.cli, Prefix+bizPrefix, timeout)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return 0, err
+	}
+	return n, nil
+}
+
+// ReapChunks removes the chunks of large values left behind by writes which
+// failed halfway and could not clean up after themselves, it returns how many
+// generations were deleted. Chunks written in the last few minutes are kept,
+// their write may still be running.
+func (p *Config) ReapChunks(timeout time.Duration) (n int, err error) {
+	defer observeOp("reap_chunks", time.Now(), &err)
+	err = p.EnsureConnected()
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return 0, err
+	}
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	n, err = reapChunks(p.cli, timeout)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return n, err
+	}
+	return n, nil
+}
+
+func (p *Config) kv2Item(kv *etcdutils.Kv) (*Item, error) {
+	val, err := decodeValue(p.cli, kv.Key, kv.Val, kv.Rev)
+	if err != nil {
+		log.Errorf("decode %s err:%v", kv.Key, err)
+		return nil, err
+	}
+	var key string
+	if strings.HasPrefix(kv.Key, Prefix) {
+		key = kv.Key[len(Prefix):]
+	} else {
+		key = kv.Key
+	}
+	return &Item{
+		Key:    key,
+		Val:    val,
+		Ver:    kv.Ver,
+		Source: SourceRemote,
+	}, nil
+}
+
+func (p *Config) Set(key string, val string) (err error) {
+	defer observeOp("set", time.Now(), &err)
+	err = p.EnsureConnected()
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return err
+	}
+	_, err = putValue(p.cli, Prefix+key, val, -1, 3*time.Second)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return err
+	}
+	if len(val) > 1024 {
+		log.Infof("set %s to %d bytes", key, len(val))
+	} else {
+		log.Infof("set %s to %s", key, val)
+	}
 	return nil
 }
 
@@ -133,13 +298,14 @@ func (p *Config) SetJson(key string, val interface{}) error {
 	return p.Set(key, string(j))
 }
 
-func (p *Config) Del(key string) error {
-	err := p.EnsureConnected()
+func (p *Config) Del(key string) (err error) {
+	defer observeOp("del", time.Now(), &err)
+	err = p.EnsureConnected()
 	if err != nil {
 		log.Errorf("err:%v", err)
 		return err
 	}
-	err = etcdutils.Del(p.cli, Prefix+key, 3*time.Second)
+	err = delValue(p.cli, Prefix+key, 3*time.Second)
 	if err != nil {
 		log.Errorf("err:%v", err)
 		return err
@@ -147,13 +313,14 @@ func (p *Config) Del(key string) error {
 	return nil
 }
 
-func (p *Config) SetCheckVer(key string, val string, ver int64) error {
-	err := p.EnsureConnected()
+func (p *Config) SetCheckVer(key string, val string, ver int64) (err error) {
+	defer observeOp("set_check_ver", time.Now(), &err)
+	err = p.EnsureConnected()
 	if err != nil {
 		log.Errorf("err:%v", err)
 		return err
 	}
-	ok, err := etcdutils.SetWithVersion(p.cli, Prefix+key, val, ver, 3*time.Second)
+	ok, err := putValue(p.cli, Prefix+key, val, ver, 3*time.Second)
 	if err != nil {
 		log.Errorf("err:%v", err)
 		return err
@@ -167,34 +334,128 @@ func (p *Config) SetCheckVer(key string, val string, ver int64) error {
 }
 
 func (p *Config) Get(key string, fromLocalFs *bool) (*Item, error) {
-	{
-		item, err := tryGetLocalFs(key)
-		if err == nil && item != nil {
-			if fromLocalFs != nil {
-				*fromLocalFs = true
-			}
+	item, err := p.GetWithOpt(key, nil)
+	if err != nil {
+		return nil, err
+	}
+	if fromLocalFs != nil {
+		*fromLocalFs = item.Source == SourceLocalFs
+	}
+	return item, nil
+}
+
+func (p *Config) readOpt(opt *GetOpt) (ReadPolicy, time.Duration) {
+	policy := p.ReadPolicy
+	maxStaleness := p.MaxLocalStaleness
+	if opt != nil {
+		if opt.Policy != ReadDefault {
+			policy = opt.Policy
+		}
+		if opt.MaxStaleness > 0 {
+			maxStaleness = opt.MaxStaleness
+		}
+	}
+	if policy == ReadDefault {
+		policy = ReadLocalFirst
+	}
+	return policy, maxStaleness
+}
+
+func (p *Config) GetWithOpt(key string, opt *GetOpt) (item *Item, err error) {
+	defer observeOp("get", time.Now(), &err)
+	item, err = p.getWithOpt(key, opt)
+	if err == nil {
+		observeRead(key, item)
+	}
+	return
+}
+
+func (p *Config) getWithOpt(key string, opt *GetOpt) (*Item, error) {
+	policy, maxStaleness := p.readOpt(opt)
+	switch policy {
+	case ReadLocalOnly:
+		return p.getLocal(key, maxStaleness)
+	case ReadRemoteOnly:
+		return p.getRemote(key)
+	case ReadRemoteFirst:
+		item, err := p.getRemote(key)
+		if err == nil {
 			return item, nil
 		}
+		local, localErr := p.getLocal(key, maxStaleness)
+		if localErr != nil {
+			return p.fallbackIfCircuitOpen(key, err)
+		}
+		log.Warnf("remote get %s failed, use local copy, age %s", key, local.Age)
+		return local, nil
+	default:
+		item, err := p.getLocal(key, maxStaleness)
+		if err == nil {
+			return item, nil
+		}
+		item, err = p.getRemote(key)
+		if err != nil {
+			return p.fallbackIfCircuitOpen(key, err)
+		}
+		return item, nil
+	}
+}
+
+// fallbackIfCircuitOpen serves the local copy regardless of its age while etcd
+// is known to be down, if the Config opted in with StaleIfCircuitOpen
+func (p *Config) fallbackIfCircuitOpen(key string, err error) (*Item, error) {
+	if err != etcdutils.ErrCircuitOpen || !p.StaleIfCircuitOpen {
+		return nil, err
+	}
+	item, localErr := p.getLocal(key, 0)
+	if localErr != nil {
+		return nil, err
+	}
+	log.Warnf("etcd circuit open, use local copy of %s, age %s", key, item.Age)
+	return item, nil
+}
+
+func (p *Config) getLocal(key string, maxStaleness time.Duration) (*Item, error) {
+	item, err := tryGetLocalFs(key)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrLocalMiss
+		}
+		return nil, err
 	}
+	if item == nil {
+		return nil, ErrLocalMiss
+	}
+	if maxStaleness > 0 && item.Age > maxStaleness {
+		log.Warnf("local copy of %s is stale, age %s, max %s", key, item.Age, maxStaleness)
+		return nil, ErrLocalStale
+	}
+	return item, nil
+}
+
+func (p *Config) getRemote(key string) (*Item, error) {
 	err := p.EnsureConnected()
 	if err != nil {
 		log.Errorf("err:%v", err)
 		return nil, err
 	}
-	val, ver, err := etcdutils.GetWithVersion(
+	kv, err := etcdutils.GetKv(
 		p.cli, Prefix+key, 3*time.Second)
 	if err != nil {
 		c := etcdclient.GetEtcdConfig()
 		log.Errorf("etcd get err %v, server %v", err, c.GetEndpointList())
 		return nil, err
 	}
-	if fromLocalFs != nil {
-		*fromLocalFs = false
+	val, err := decodeValue(p.cli, Prefix+key, kv.Val, kv.Rev)
+	if err != nil {
+		log.Errorf("decode %s err:%v", key, err)
+		return nil, err
 	}
 	return &Item{
-		Key: key,
-		Val: val,
-		Ver: ver,
+		Key:    key,
+		Val:    val,
+		Ver:    kv.Ver,
+		Source: SourceRemote,
 	}, nil
 }
 
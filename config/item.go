@@ -1,8 +1,11 @@
 package config
 
 import (
+	"context"
+	"fmt"
 	"github.com/easygf/core/json"
 	"github.com/easygf/core/log"
+	"github.com/easygf/core/log/atexit"
 	"github.com/howeyc/fsnotify"
 	"io"
 	"os"
@@ -15,10 +18,32 @@ const (
 	ItemDelete = 3
 )
 
+type ItemSource int
+
+const (
+	SourceNone ItemSource = iota
+	SourceLocalFs
+	SourceRemote
+)
+
+func (s ItemSource) String() string {
+	switch s {
+	case SourceLocalFs:
+		return "local_fs"
+	case SourceRemote:
+		return "remote"
+	default:
+		return "none"
+	}
+}
+
 type Item struct {
 	Key string
 	Val string
 	Ver int64
+	// where the item was read from, and for local copies how long ago the file was written
+	Source ItemSource    `json:"-"`
+	Age    time.Duration `json:"-"`
 }
 
 func tryGetLocalFs(key string) (*Item, error) {
@@ -33,6 +58,11 @@ func tryGetLocalFs(key string) (*Item, error) {
 	defer func() {
 		_ = fp.Close()
 	}()
+	st, err := fp.Stat()
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return nil, err
+	}
 	buf, err := io.ReadAll(fp)
 	if err != nil {
 		log.Errorf("err:%v", err)
@@ -51,6 +81,18 @@ func tryGetLocalFs(key string) (*Item, error) {
 		log.Errorf("invalid item %+v", item)
 		return nil, nil
 	}
+	item.Val, err = decodeValue(nil, Prefix+key, item.Val, 0)
+	if err != nil {
+		if err != ErrLocalChunked {
+			log.Errorf("decode %s err:%v", key, err)
+		}
+		return nil, err
+	}
+	item.Source = SourceLocalFs
+	item.Age = time.Since(st.ModTime())
+	if item.Age < 0 {
+		item.Age = 0
+	}
 	return &item, nil
 }
 
@@ -70,6 +112,8 @@ type ItemWatcher struct {
 	callback   func(ev int, item *Item)
 	notifyExit chan bool
 	watcher    *fsnotify.Watcher
+	// reads the chunked values, used by the watch loop only
+	remote *Config
 }
 
 func NewItemWatcher(key string) *ItemWatcher {
@@ -86,8 +130,28 @@ func fileExist(filename string) bool {
 	return true
 }
 
+// load reads the local copy, a chunked value is fetched from etcd as the file
+// only holds its manifest, one read per file update keeps one callback per
+// logical update
+func (p *ItemWatcher) load() (*Item, error) {
+	item, err := tryGetLocalFs(p.key)
+	if err != ErrLocalChunked {
+		return item, err
+	}
+	if p.remote == nil {
+		p.remote = NewConfig()
+	}
+	return p.remote.getRemote(p.key)
+}
+
 func (p *ItemWatcher) watchLoop() error {
 	filePath := GetFilePathByKey(p.key)
+	defer func() {
+		if p.remote != nil {
+			p.remote.CloseIgnoreError()
+			p.remote = nil
+		}
+	}()
 	for {
 		if p.watcher == nil {
 			select {
@@ -96,7 +160,7 @@ func (p *ItemWatcher) watchLoop() error {
 			default:
 			}
 			if fileExist(filePath) {
-				item, err := tryGetLocalFs(p.key)
+				item, err := p.load()
 				if err == nil && item != nil {
 					if p.callback != nil {
 						p.callback(ItemCreate, item)
@@ -135,7 +199,7 @@ func (p *ItemWatcher) watchLoop() error {
 					// skip
 				} else {
 					log.Infof("%s update", p.key)
-					newItem, err := tryGetLocalFs(p.key)
+					newItem, err := p.load()
 					if err == nil && newItem != nil {
 						if p.callback != nil {
 							p.callback(ItemUpdate, newItem)
@@ -164,11 +228,19 @@ func (p *ItemWatcher) Start(callback func(ev int, item *Item)) error {
 			log.Infof("watch loop for key %s exit", p.key)
 			return nil
 		})
+		atexit.RegisterHook(p.hookName(), atexit.PriorityWatcher, time.Second, func(ctx context.Context) error {
+			return p.Stop()
+		})
 	}
 	return nil
 }
 
+func (p *ItemWatcher) hookName() string {
+	return fmt.Sprintf("config_watcher_%s_%p", p.key, p)
+}
+
 func (p *ItemWatcher) Stop() error {
+	atexit.UnregisterHook(p.hookName())
 	if p.notifyExit != nil {
 		select {
 		case p.notifyExit <- true:
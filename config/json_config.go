@@ -57,6 +57,13 @@ func (p *JsonConfig) InitV2(logic ChangedCb) error {
 		return err
 	}
 	p.existed = existed
+	if getObserver().JsonConfig != nil {
+		// GetJson keeps the version to itself, read it again for the observer
+		item, err := c.getWithOpt(p.key, nil)
+		if err == nil {
+			observeJsonConfig(p.key, item.Ver)
+		}
+	}
 	watcher := NewItemWatcher(p.key)
 	err = watcher.Start(func(ev int, item *Item) {
 		old := p.val
@@ -69,9 +76,11 @@ func (p *JsonConfig) InitV2(logic ChangedCb) error {
 				log.Errorf("err:%v", err)
 			} else {
 				p.val = v
+				observeJsonConfig(p.key, item.Ver)
 			}
 		case ItemDelete:
 			p.existed = false
+			observeJsonConfig(p.key, 0)
 		}
 		if logic != nil {
 			logic(ev, old, p.val)
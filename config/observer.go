@@ -0,0 +1,44 @@
+package config
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Observer receives config activity, any field may be nil
+type Observer struct {
+	Op         func(op string, cost time.Duration, err error)
+	Read       func(key string, source ItemSource)
+	JsonConfig func(key string, ver int64, updatedAt time.Time)
+}
+
+var observer atomic.Value // Observer
+
+// SetObserver may be called at any time, operations already running may still
+// report to the previous one
+func SetObserver(o Observer) {
+	observer.Store(o)
+}
+
+func getObserver() Observer {
+	o, _ := observer.Load().(Observer)
+	return o
+}
+
+func observeOp(op string, start time.Time, err *error) {
+	if o := getObserver(); o.Op != nil {
+		o.Op(op, time.Since(start), *err)
+	}
+}
+
+func observeRead(key string, item *Item) {
+	if o := getObserver(); o.Read != nil && item != nil {
+		o.Read(key, item.Source)
+	}
+}
+
+func observeJsonConfig(key string, ver int64) {
+	if o := getObserver(); o.JsonConfig != nil {
+		o.JsonConfig(key, ver, time.Now())
+	}
+}
@@ -26,12 +26,19 @@ func GetStr(key string) (val string, ver int64, err error) {
 	}(cli)
 	var realKey string
 	realKey = Prefix + key
-	val, ver, err = etcdutils.GetWithVersion(
+	var kv *etcdutils.Kv
+	kv, err = etcdutils.GetKv(
 		cli, realKey, etcdclient.GetEtcdConfig().GetOpTimeout())
 	if err != nil {
 		log.Error(err)
 		return
 	}
+	ver = kv.Ver
+	val, err = decodeValue(cli, realKey, kv.Val, kv.Rev)
+	if err != nil {
+		log.Error(err)
+		return
+	}
 	return
 }
 
@@ -50,12 +57,19 @@ func GetStrPrefix(key, prefix string) (val string, ver int64, err error) {
 	}(cli)
 	var realKey string
 	realKey = prefix + key
-	val, ver, err = etcdutils.GetWithVersion(
+	var kv *etcdutils.Kv
+	kv, err = etcdutils.GetKv(
 		cli, realKey, etcdclient.GetEtcdConfig().GetOpTimeout())
 	if err != nil {
 		log.Error(err)
 		return
 	}
+	ver = kv.Ver
+	val, err = decodeValue(cli, realKey, kv.Val, kv.Rev)
+	if err != nil {
+		log.Error(err)
+		return
+	}
 	return
 }
 
@@ -74,7 +88,7 @@ func SetStr(key string, val string, ver int64) (err error) {
 	}(cli)
 	realKey := Prefix + key
 	var res bool
-	res, err = etcdutils.SetWithVersion(
+	res, err = putValue(
 		cli, realKey, val, ver, etcdclient.GetEtcdConfig().GetOpTimeout())
 	if err != nil {
 		log.Error(err)
@@ -103,7 +117,7 @@ func SetStrPrefix(key, prefix, val string, ver int64) (err error) {
 	}(cli)
 	realKey := prefix + key
 	var res bool
-	res, err = etcdutils.SetWithVersion(
+	res, err = putValue(
 		cli, realKey, val, ver, etcdclient.GetEtcdConfig().GetOpTimeout())
 	if err != nil {
 		log.Error(err)
@@ -131,7 +145,7 @@ func Del(key string) (err error) {
 		}
 	}(cli)
 	realKey := Prefix + key
-	err = etcdutils.Del(
+	err = delValue(
 		cli, realKey, etcdclient.GetEtcdConfig().GetOpTimeout())
 	if err != nil {
 		log.Error(err)
@@ -156,7 +170,12 @@ func Watch(key string, cb func(val string, isDelete bool) (stopWatch bool)) erro
 	for rsp := range watchChan {
 		for _, ev := range rsp.Events {
 			if ev.Type == mvccpb.PUT {
-				stop := cb(string(ev.Kv.Value), false)
+				val, err := decodeValue(cli, realKey, string(ev.Kv.Value), ev.Kv.ModRevision)
+				if err != nil {
+					log.Errorf("decode %s err:%v", realKey, err)
+					continue
+				}
+				stop := cb(val, false)
 				if stop {
 					return nil
 				}
@@ -1,20 +1,80 @@
 package etcdclient
 
 import (
+	"context"
 	"errors"
+	"sync"
 
 	"github.com/coreos/etcd/clientv3"
+	"github.com/easygf/core/log"
+	"github.com/easygf/core/log/atexit"
+	"go.uber.org/zap"
 )
 
+// clients still open, closed on shutdown
+var openClients = map[*clientv3.Client]bool{}
+var openClientsMu sync.Mutex
+
+// DisableLogAdapter keeps the zap logs of the clients made by New on stderr.
+// The grpc logs go to the log package from init on, before any grpc goroutine
+// may read the logger, an application wanting them elsewhere calls
+// clientv3.SetLogger itself first thing in main.
+var DisableLogAdapter bool
+
+func init() {
+	atexit.RegisterHook("etcdclient", atexit.PriorityClient, 0, closeAll)
+	clientv3.SetLogger(log.NewGrpcLogger(0))
+}
+
 func New() (*clientv3.Client, error) {
 	c := GetEtcdConfig()
 	epList := c.GetEndpointList()
 	if len(epList) == 0 {
 		return nil, errors.New("invalid etcd config")
 	}
+	var zapConfig *zap.Config
+	if !DisableLogAdapter {
+		zapConfig = log.NewZapConfig()
+	}
 	cli, err := clientv3.New(clientv3.Config{
 		Endpoints:   epList,
 		DialTimeout: c.GetConnectTimeout(),
+		LogConfig:   zapConfig,
 	})
+	if err != nil {
+		return cli, err
+	}
+	openClientsMu.Lock()
+	openClients[cli] = true
+	openClientsMu.Unlock()
+	go func() {
+		<-cli.Ctx().Done()
+		openClientsMu.Lock()
+		delete(openClients, cli)
+		openClientsMu.Unlock()
+	}()
 	return cli, err
-}
\ No newline at end of file
+}
+
+func closeAll(ctx context.Context) error {
+	openClientsMu.Lock()
+	list := make([]*clientv3.Client, 0, len(openClients))
+	for cli := range openClients {
+		list = append(list, cli)
+	}
+	openClientsMu.Unlock()
+	var err error
+	for _, cli := range list {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if cli.Ctx().Err() != nil {
+			// closed meanwhile
+			continue
+		}
+		if e := cli.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -10,17 +10,21 @@ type Kv struct {
 	Key string
 	Val string
 	Ver int64
+	// store revision the kv was read at, reading other keys at it gives a consistent view
+	Rev int64
 }
 
 func SetWithVersion(
 	cli *clientv3.Client, key, val string,
 	version int64, timeout time.Duration) (bool, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	txnRsp, err := cli.Txn(ctx).
-		If(clientv3.Compare(clientv3.Version(key), "=", version)).
-		Then(clientv3.OpPut(key, val)).
-		Commit()
-	cancel()
+	var txnRsp *clientv3.TxnResponse
+	err := do("cas_put", timeout, true, func(ctx context.Context) (err error) {
+		txnRsp, err = cli.Txn(ctx).
+			If(clientv3.Compare(clientv3.Version(key), "=", version)).
+			Then(clientv3.OpPut(key, val)).
+			Commit()
+		return
+	})
 	if err != nil {
 		return false, err
 	}
@@ -28,18 +32,20 @@ func SetWithVersion(
 }
 
 func Set(cli *clientv3.Client, key, val string, timeout time.Duration) error {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	_, err := cli.Put(ctx, key, val)
-	cancel()
-	return err
+	return do("put", timeout, false, func(ctx context.Context) error {
+		_, err := cli.Put(ctx, key, val)
+		return err
+	})
 }
 
 func GetWithVersion(
 	cli *clientv3.Client, key string,
 	timeout time.Duration) (val string, version int64, err error) {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	rsp, err := cli.Get(ctx, key)
-	cancel()
+	var rsp *clientv3.GetResponse
+	err = do("get", timeout, true, func(ctx context.Context) (err error) {
+		rsp, err = cli.Get(ctx, key)
+		return
+	})
 	val = ""
 	version = 0
 	if err == nil && len(rsp.Kvs) > 0 {
@@ -49,29 +55,60 @@ func GetWithVersion(
 	return
 }
 
-func GetWithPrefix(cli *clientv3.Client, prefix string, timeout time.Duration) ([]*Kv, error) {
-	var kvs []*Kv
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	rsp, err := cli.Get(ctx, prefix, clientv3.WithPrefix())
-	cancel()
+// GetKv reads key with the revision of the read, a missing key has an empty Val and Ver 0
+func GetKv(cli *clientv3.Client, key string, timeout time.Duration) (*Kv, error) {
+	var rsp *clientv3.GetResponse
+	err := do("get", timeout, true, func(ctx context.Context) (err error) {
+		rsp, err = cli.Get(ctx, key)
+		return
+	})
 	if err != nil {
 		return nil, err
 	}
-	for _, n := range rsp.Kvs {
-		kvs = append(
-			kvs,
-			&Kv{
-				Key: string(n.Key),
-				Val: string(n.Value),
-				Ver: n.Version,
-			})
+	if len(rsp.Kvs) == 0 {
+		return &Kv{Key: key, Rev: rsp.Header.Revision}, nil
+	}
+	return toKvList(rsp, rsp.Header.Revision)[0], nil
+}
+
+func GetWithPrefix(cli *clientv3.Client, prefix string, timeout time.Duration) ([]*Kv, error) {
+	var rsp *clientv3.GetResponse
+	err := do("get_prefix", timeout, true, func(ctx context.Context) (err error) {
+		rsp, err = cli.Get(ctx, prefix, clientv3.WithPrefix())
+		return
+	})
+	if err != nil {
+		return nil, err
 	}
-	return kvs, nil
+	return toKvList(rsp, rsp.Header.Revision), nil
 }
 
 func Del(cli *clientv3.Client, key string, timeout time.Duration) error {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	_, err := cli.Delete(ctx, key)
-	cancel()
-	return err
+	return do("del", timeout, false, func(ctx context.Context) error {
+		_, err := cli.Delete(ctx, key)
+		return err
+	})
+}
+
+// Commit applies ops in one transaction if all cmps hold, it is retried only
+// when guarded by cmps since an unconditional txn may be applied twice
+func Commit(
+	cli *clientv3.Client, cmps []clientv3.Cmp, ops []clientv3.Op,
+	timeout time.Duration) (bool, error) {
+	var txnRsp *clientv3.TxnResponse
+	err := do("txn", timeout, len(cmps) > 0, func(ctx context.Context) (err error) {
+		txnRsp, err = cli.Txn(ctx).If(cmps...).Then(ops...).Commit()
+		return
+	})
+	if err != nil {
+		return false, err
+	}
+	return txnRsp.Succeeded, nil
+}
+
+func DelWithPrefix(cli *clientv3.Client, prefix string, timeout time.Duration) error {
+	return do("del_prefix", timeout, false, func(ctx context.Context) error {
+		_, err := cli.Delete(ctx, prefix, clientv3.WithPrefix())
+		return err
+	})
 }
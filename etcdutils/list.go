@@ -0,0 +1,235 @@
+package etcdutils
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+)
+
+const defaultPageSize = 500
+
+type SortBy int
+
+const (
+	SortByKey SortBy = iota
+	SortByVersion
+	SortByCreateRev
+	SortByModRev
+)
+
+func (s SortBy) target() clientv3.SortTarget {
+	switch s {
+	case SortByVersion:
+		return clientv3.SortByVersion
+	case SortByCreateRev:
+		return clientv3.SortByCreateRevision
+	case SortByModRev:
+		return clientv3.SortByModRevision
+	default:
+		return clientv3.SortByKey
+	}
+}
+
+type ListOpt struct {
+	// keys per request, 0 means defaultPageSize
+	PageSize int64
+	SortBy   SortBy
+	Descend  bool
+	KeysOnly bool
+	// stop after this many keys, 0 means no limit
+	Limit int64
+	// list as of this store revision, 0 means the current one
+	Rev int64
+}
+
+func (o *ListOpt) pageSize() int64 {
+	if o == nil || o.PageSize <= 0 {
+		return defaultPageSize
+	}
+	return o.PageSize
+}
+
+// ErrIterSortBy is returned by the iterator for a ListOpt.SortBy other than SortByKey
+var ErrIterSortBy = errors.New("prefix iterator only sorts by key")
+
+// PrefixIterator walks a prefix page by page in key order, every page is read
+// at the revision of the first one so the listing is a consistent snapshot.
+// Sorting by anything but the key cannot be continued across pages, such
+// listings are fetched in one request, see ListKeysWithPrefix, the iterator
+// fails with ErrIterSortBy.
+//
+//	it := NewPrefixIterator(cli, prefix, &ListOpt{PageSize: 100}, time.Second)
+//	for it.Next() {
+//		for _, kv := range it.Page() {
+//		}
+//	}
+//	if it.Err() != nil {
+//	}
+type PrefixIterator struct {
+	cli     *clientv3.Client
+	opt     ListOpt
+	timeout time.Duration
+	// remaining range is [start, end)
+	start string
+	end   string
+	rev   int64
+	total int64
+	page  []*Kv
+	done  bool
+	err   error
+}
+
+func NewPrefixIterator(cli *clientv3.Client, prefix string, opt *ListOpt, timeout time.Duration) *PrefixIterator {
+	it := &PrefixIterator{
+		cli:     cli,
+		timeout: timeout,
+		start:   prefix,
+		end:     clientv3.GetPrefixRangeEnd(prefix),
+	}
+	if opt != nil {
+		it.opt = *opt
+	}
+	it.opt.PageSize = opt.pageSize()
+	it.rev = it.opt.Rev
+	if it.opt.SortBy != SortByKey {
+		it.err = ErrIterSortBy
+	}
+	return it
+}
+
+func (it *PrefixIterator) Next() bool {
+	if it.done || it.err != nil {
+		it.page = nil
+		return false
+	}
+	limit := it.opt.PageSize
+	if it.opt.Limit > 0 && it.opt.Limit-it.total < limit {
+		limit = it.opt.Limit - it.total
+	}
+	order := clientv3.SortAscend
+	if it.opt.Descend {
+		order = clientv3.SortDescend
+	}
+	opts := []clientv3.OpOption{
+		clientv3.WithRange(it.end),
+		clientv3.WithLimit(limit),
+		clientv3.WithSort(clientv3.SortByKey, order),
+	}
+	if it.rev > 0 {
+		opts = append(opts, clientv3.WithRev(it.rev))
+	}
+	if it.opt.KeysOnly {
+		opts = append(opts, clientv3.WithKeysOnly())
+	}
+	var rsp *clientv3.GetResponse
+	err := do("list_page", it.timeout, true, func(ctx context.Context) (err error) {
+		rsp, err = it.cli.Get(ctx, it.start, opts...)
+		return
+	})
+	if err != nil {
+		it.err = err
+		it.page = nil
+		return false
+	}
+	if it.rev == 0 {
+		it.rev = rsp.Header.Revision
+	}
+	it.page = toKvList(rsp, it.rev)
+	it.total += int64(len(it.page))
+	if !rsp.More || len(rsp.Kvs) == 0 || (it.opt.Limit > 0 && it.total >= it.opt.Limit) {
+		it.done = true
+	} else {
+		last := string(rsp.Kvs[len(rsp.Kvs)-1].Key)
+		if it.opt.Descend {
+			it.end = last
+		} else {
+			it.start = last + "\x00"
+		}
+	}
+	return len(it.page) > 0
+}
+
+func (it *PrefixIterator) Page() []*Kv {
+	return it.page
+}
+
+func (it *PrefixIterator) Err() error {
+	return it.err
+}
+
+// Revision is the etcd revision the listing is pinned to, valid after the first Next
+func (it *PrefixIterator) Revision() int64 {
+	return it.rev
+}
+
+func toKvList(rsp *clientv3.GetResponse, rev int64) []*Kv {
+	kvs := make([]*Kv, 0, len(rsp.Kvs))
+	for _, n := range rsp.Kvs {
+		kvs = append(
+			kvs,
+			&Kv{
+				Key: string(n.Key),
+				Val: string(n.Value),
+				Ver: n.Version,
+				Rev: rev,
+			})
+	}
+	return kvs
+}
+
+// ListKeysWithPrefix returns only the keys under prefix, values are never transferred
+func ListKeysWithPrefix(cli *clientv3.Client, prefix string, opt *ListOpt, timeout time.Duration) ([]string, error) {
+	o := ListOpt{}
+	if opt != nil {
+		o = *opt
+	}
+	o.KeysOnly = true
+	var keys []string
+	if o.SortBy == SortByKey {
+		it := NewPrefixIterator(cli, prefix, &o, timeout)
+		for it.Next() {
+			for _, kv := range it.Page() {
+				keys = append(keys, kv.Key)
+			}
+		}
+		return keys, it.Err()
+	}
+	order := clientv3.SortAscend
+	if o.Descend {
+		order = clientv3.SortDescend
+	}
+	opts := []clientv3.OpOption{
+		clientv3.WithPrefix(),
+		clientv3.WithKeysOnly(),
+		clientv3.WithSort(o.SortBy.target(), order),
+	}
+	if o.Limit > 0 {
+		opts = append(opts, clientv3.WithLimit(o.Limit))
+	}
+	var rsp *clientv3.GetResponse
+	err := do("list_keys", timeout, true, func(ctx context.Context) (err error) {
+		rsp, err = cli.Get(ctx, prefix, opts...)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range rsp.Kvs {
+		keys = append(keys, string(n.Key))
+	}
+	return keys, nil
+}
+
+func CountWithPrefix(cli *clientv3.Client, prefix string, timeout time.Duration) (int64, error) {
+	var rsp *clientv3.GetResponse
+	err := do("count", timeout, true, func(ctx context.Context) (err error) {
+		rsp, err = cli.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+		return
+	})
+	if err != nil {
+		return 0, err
+	}
+	return rsp.Count, nil
+}
@@ -0,0 +1,216 @@
+package etcdutils
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var ErrCircuitOpen = errors.New("etcd circuit open")
+
+type RetryPolicy struct {
+	// total attempts including the first one, <= 1 disables retry
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// plain puts and deletes are not retried unless set, they may be applied twice
+	RetryUnsafe bool
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   50 * time.Millisecond,
+	MaxDelay:    time.Second,
+}
+
+var retryPolicy = DefaultRetryPolicy
+var retryPolicyMu sync.RWMutex
+
+func SetRetryPolicy(p RetryPolicy) {
+	retryPolicyMu.Lock()
+	retryPolicy = p
+	retryPolicyMu.Unlock()
+}
+
+func GetRetryPolicy() RetryPolicy {
+	retryPolicyMu.RLock()
+	p := retryPolicy
+	retryPolicyMu.RUnlock()
+	return p
+}
+
+// backoff returns the full-jitter exponential delay before the retry following attempt n
+func (p RetryPolicy) backoff(n int) time.Duration {
+	d := p.BaseDelay
+	if d <= 0 {
+		return 0
+	}
+	for i := 0; i < n && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(d)) + 1)
+}
+
+// IsTransient reports whether err is worth retrying, i.e. the cluster is
+// electing a leader, overloaded or unreachable
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	if err == context.DeadlineExceeded {
+		return true
+	}
+	switch err {
+	case rpctypes.ErrNoLeader, rpctypes.ErrNotLeader, rpctypes.ErrLeaderChanged,
+		rpctypes.ErrStopped, rpctypes.ErrTimeout, rpctypes.ErrTimeoutDueToLeaderFail,
+		rpctypes.ErrTimeoutDueToConnectionLost, rpctypes.ErrUnhealthy:
+		return true
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// Breaker stops sending requests once Threshold transient failures happen in a
+// row, after Cooldown a single probe is let through to check the cluster again
+type Breaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu        sync.Mutex
+	state     circuitState
+	failures  int
+	openUntil time.Time
+}
+
+var breaker = &Breaker{
+	Threshold: 5,
+	Cooldown:  10 * time.Second,
+}
+
+func SetBreaker(threshold int, cooldown time.Duration) {
+	breaker.mu.Lock()
+	breaker.Threshold = threshold
+	breaker.Cooldown = cooldown
+	breaker.state = circuitClosed
+	breaker.failures = 0
+	breaker.mu.Unlock()
+}
+
+// CircuitOpen reports whether the cluster is currently considered down
+func CircuitOpen() bool {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	return breaker.state == circuitOpen && time.Now().Before(breaker.openUntil)
+}
+
+func (b *Breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.Threshold <= 0 {
+		return true
+	}
+	switch b.state {
+	case circuitOpen:
+		if time.Now().Before(b.openUntil) {
+			return false
+		}
+		b.state = circuitHalfOpen
+		return true
+	case circuitHalfOpen:
+		// probe in flight
+		return false
+	default:
+		return true
+	}
+}
+
+func (b *Breaker) report(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.Threshold <= 0 {
+		return
+	}
+	if !IsTransient(err) {
+		b.state = circuitClosed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= b.Threshold {
+		b.state = circuitOpen
+		b.openUntil = time.Now().Add(b.Cooldown)
+	}
+}
+
+type observerFunc func(op string, cost time.Duration, err error)
+
+var observer atomic.Value // observerFunc
+
+// SetObserver installs a callback invoked after every operation with its total
+// cost including retries, it may be set at any time
+func SetObserver(o func(op string, cost time.Duration, err error)) {
+	observer.Store(observerFunc(o))
+}
+
+// do runs fn until it succeeds, fails permanently or timeout elapses,
+// each attempt shares the same deadline
+func do(op string, timeout time.Duration, safe bool, fn func(ctx context.Context) error) (err error) {
+	if o, _ := observer.Load().(observerFunc); o != nil {
+		start := time.Now()
+		defer func() {
+			o(op, time.Since(start), err)
+		}()
+	}
+	if !breaker.allow() {
+		return ErrCircuitOpen
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	p := GetRetryPolicy()
+	attempts := p.MaxAttempts
+	if attempts < 1 || (!safe && !p.RetryUnsafe) {
+		attempts = 1
+	}
+	for i := 0; ; i++ {
+		err = fn(ctx)
+		breaker.report(err)
+		if err == nil || i+1 >= attempts || !IsTransient(err) {
+			return err
+		}
+		delay := p.backoff(i)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= delay {
+			return err
+		}
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		}
+		if !breaker.allow() {
+			return err
+		}
+	}
+}
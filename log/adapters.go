@@ -0,0 +1,171 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	stdlog "log"
+	"runtime"
+	"strings"
+
+	"google.golang.org/grpc/grpclog"
+)
+
+// selfPrefix is the function name prefix of this package
+var selfPrefix = func() string {
+	pc, _, _, _ := runtime.Caller(0)
+	pkg, _ := getPackageName(runtime.FuncForPC(pc).Name())
+	return pkg + "."
+}()
+
+// externalCaller returns the first caller outside this package and outside the
+// functions named with one of prefixes, nil if there is none
+func externalCaller(prefixes ...string) *Optimization {
+	var pcs [32]uintptr
+	n := runtime.Callers(2, pcs[:])
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		f, more := frames.Next()
+		if !hasAnyPrefix(f.Function, selfPrefix) && !hasAnyPrefix(f.Function, prefixes...) {
+			return &Optimization{ShortFile: f.File, CallerName: f.Function, CallerLine: f.Line}
+		}
+		if !more {
+			return nil
+		}
+	}
+}
+
+func hasAnyPrefix(s string, prefixes ...string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+type stdWriter struct {
+	l Level
+}
+
+// the standard library log package
+const stdlogPrefix = "log."
+
+// NewStdWriter returns a writer logging every Write as a line at l, meant
+// as the output of a standard library logger
+func NewStdWriter(l Level) io.Writer {
+	return &stdWriter{l: l}
+}
+
+func (w *stdWriter) Write(p []byte) (int, error) {
+	if !Default().Enabled(w.l) {
+		return len(p), nil
+	}
+	logIt(Default(), nil, externalCaller(stdlogPrefix), w.l, nil, strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+// NewStdLogger returns a standard library logger writing into this package at l
+func NewStdLogger(l Level) *stdlog.Logger {
+	return stdlog.New(NewStdWriter(l), "", 0)
+}
+
+// RedirectStdLog sends the lines of the standard library package level
+// logger here at l, the returned func restores its previous settings
+func RedirectStdLog(l Level) func() {
+	flags, prefix, out := stdlog.Flags(), stdlog.Prefix(), stdlog.Writer()
+	stdlog.SetFlags(0)
+	stdlog.SetPrefix("")
+	stdlog.SetOutput(NewStdWriter(l))
+	return func() {
+		stdlog.SetFlags(flags)
+		stdlog.SetPrefix(prefix)
+		stdlog.SetOutput(out)
+	}
+}
+
+// the wrappers grpclog and the etcd client put around a LoggerV2
+var grpcPrefixes = []string{
+	"google.golang.org/grpc/grpclog.",
+	"google.golang.org/grpc/internal/grpclog.",
+	"github.com/coreos/etcd/pkg/logutil.",
+	"github.com/coreos/etcd/clientv3.(*settableLogger)",
+}
+
+type grpcLogger struct {
+	verbosity int
+}
+
+// NewGrpcLogger returns a grpclog.LoggerV2 logging here, grpc info lines are
+// logged at debug level as they are mostly connection chatter, V(l) holds for
+// l up to verbosity
+//
+//	grpclog.SetLoggerV2(log.NewGrpcLogger(0))
+func NewGrpcLogger(verbosity int) grpclog.LoggerV2 {
+	return &grpcLogger{verbosity: verbosity}
+}
+
+// grpclog requires Fatal to exit, so afterLog runs even for disabled levels
+func (g *grpcLogger) print(l Level, args ...interface{}) {
+	if !Default().Enabled(l) {
+		afterLog(l, fmt.Sprint(args...))
+		return
+	}
+	logItArgsWithOpt(Default(), nil, externalCaller(grpcPrefixes...), l, nil, args...)
+}
+
+func (g *grpcLogger) printf(l Level, template string, args ...interface{}) {
+	if !Default().Enabled(l) {
+		afterLog(l, fmt.Sprintf(template, args...))
+		return
+	}
+	logItFmt(Default(), nil, externalCaller(grpcPrefixes...), l, nil, template, args...)
+}
+
+func (g *grpcLogger) println(l Level, args ...interface{}) {
+	msg := strings.TrimSuffix(fmt.Sprintln(args...), "\n")
+	if !Default().Enabled(l) {
+		afterLog(l, msg)
+		return
+	}
+	logItArgsWithOpt(Default(), nil, externalCaller(grpcPrefixes...), l, nil, msg)
+}
+
+func (g *grpcLogger) Info(args ...interface{}) {
+	g.print(DebugLevel, args...)
+}
+func (g *grpcLogger) Infoln(args ...interface{}) {
+	g.println(DebugLevel, args...)
+}
+func (g *grpcLogger) Infof(format string, args ...interface{}) {
+	g.printf(DebugLevel, format, args...)
+}
+func (g *grpcLogger) Warning(args ...interface{}) {
+	g.print(WarnLevel, args...)
+}
+func (g *grpcLogger) Warningln(args ...interface{}) {
+	g.println(WarnLevel, args...)
+}
+func (g *grpcLogger) Warningf(format string, args ...interface{}) {
+	g.printf(WarnLevel, format, args...)
+}
+func (g *grpcLogger) Error(args ...interface{}) {
+	g.print(ErrorLevel, args...)
+}
+func (g *grpcLogger) Errorln(args ...interface{}) {
+	g.println(ErrorLevel, args...)
+}
+func (g *grpcLogger) Errorf(format string, args ...interface{}) {
+	g.printf(ErrorLevel, format, args...)
+}
+func (g *grpcLogger) Fatal(args ...interface{}) {
+	g.print(FatalLevel, args...)
+}
+func (g *grpcLogger) Fatalln(args ...interface{}) {
+	g.println(FatalLevel, args...)
+}
+func (g *grpcLogger) Fatalf(format string, args ...interface{}) {
+	g.printf(FatalLevel, format, args...)
+}
+func (g *grpcLogger) V(l int) bool {
+	return l <= g.verbosity
+}
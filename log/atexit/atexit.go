@@ -1,6 +1,189 @@
-//go:build !linux
-
+// Package atexit runs named shutdown hooks once, on Shutdown or Exit, on
+// log.Fatal, and on SIGINT/SIGTERM. A normal return from main is not seen,
+// main should defer Shutdown.
+//
+// The SIGINT/SIGTERM handler is installed at init. Applications draining on
+// their own signal.Notify call StopSignals first and Shutdown at the end of
+// the drain, otherwise the handler exits under them.
+//
+// os.Exit no longer runs the hooks, callers relying on that, e.g. for the log
+// flush, call Exit instead. Code that cannot be changed gets the old behaviour
+// back with PatchOsExit at startup, on linux and where os.Exit is not inlined.
 package atexit
 
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// hooks run by ascending priority, in registration order within a priority
+const (
+	PriorityApp     = 0
+	PriorityWatcher = 100
+	PriorityClient  = 200
+	// flushes the logs last so the other hooks can log
+	PriorityLog = 1000
+)
+
+const DefaultHookTimeout = 3 * time.Second
+
+type hook struct {
+	name     string
+	priority int
+	timeout  time.Duration
+	fn       func(ctx context.Context) error
+	seq      int
+}
+
+var hooks []*hook
+var hookSeq int
+var hooksMu sync.Mutex
+
+var shutdownOnce sync.Once
+var shutdownDone = make(chan bool)
+
+var sigMu sync.Mutex
+var sigChan chan os.Signal
+
+func init() {
+	HandleSignals()
+}
+
+// HandleSignals runs the hooks on SIGINT and SIGTERM and then exits with
+// 128+signal. It is installed at init, calling it after StopSignals reinstalls it.
+func HandleSignals() {
+	sigMu.Lock()
+	defer sigMu.Unlock()
+	if sigChan != nil {
+		return
+	}
+	sigChan = make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go waitSignal(sigChan)
+}
+
+func waitSignal(c chan os.Signal) {
+	sig, ok := <-c
+	if !ok {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "got signal %s, shutting down\n", sig)
+	Shutdown()
+	code := 1
+	if s, ok := sig.(syscall.Signal); ok {
+		code = 128 + int(s)
+	}
+	os.Exit(code)
+}
+
+// StopSignals removes the handler of HandleSignals, for applications handling
+// the signals themselves
+func StopSignals() {
+	sigMu.Lock()
+	defer sigMu.Unlock()
+	if sigChan == nil {
+		return
+	}
+	signal.Stop(sigChan)
+	close(sigChan)
+	sigChan = nil
+}
+
+// RegisterHook adds a hook called name, replacing any hook of that name. fn
+// gets a ctx expiring after timeout, 0 meaning DefaultHookTimeout, and the
+// shutdown moves on to the next hook once it expires.
+func RegisterHook(name string, priority int, timeout time.Duration, fn func(ctx context.Context) error) {
+	if timeout <= 0 {
+		timeout = DefaultHookTimeout
+	}
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	hookSeq++
+	h := &hook{name: name, priority: priority, timeout: timeout, fn: fn, seq: hookSeq}
+	for i, x := range hooks {
+		if name != "" && x.name == name {
+			hooks[i] = h
+			return
+		}
+	}
+	hooks = append(hooks, h)
+}
+
+// UnregisterHook removes the hook called name
+func UnregisterHook(name string) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	for i, x := range hooks {
+		if x.name == name {
+			hooks = append(hooks[:i:i], hooks[i+1:]...)
+			return
+		}
+	}
+}
+
+// Register adds an unnamed hook run with PriorityApp
 func Register(callback func()) {
+	RegisterHook("", PriorityApp, 0, func(ctx context.Context) error {
+		callback()
+		return nil
+	})
+}
+
+// Shutdown runs the hooks, only the first call does, the others wait for it to finish
+func Shutdown() {
+	shutdownOnce.Do(func() {
+		runHooks()
+		close(shutdownDone)
+	})
+	<-shutdownDone
+}
+
+// Exit runs Shutdown and exits with code
+func Exit(code int) {
+	Shutdown()
+	os.Exit(code)
+}
+
+func runHooks() {
+	hooksMu.Lock()
+	list := make([]*hook, len(hooks))
+	copy(list, hooks)
+	hooksMu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].priority != list[j].priority {
+			return list[i].priority < list[j].priority
+		}
+		return list[i].seq < list[j].seq
+	})
+	for _, h := range list {
+		runHook(h)
+	}
+}
+
+func runHook(h *hook) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		done <- h.fn(ctx)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "shutdown hook %s fail, err %s\n", h.name, err)
+		}
+	case <-ctx.Done():
+		fmt.Fprintf(os.Stderr, "shutdown hook %s timeout after %s\n", h.name, h.timeout)
+	}
 }
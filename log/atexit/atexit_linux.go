@@ -9,37 +9,28 @@ import (
 	"sync"
 )
 
-var exitCallbackList []func()
-var exitCallbackListMu sync.Mutex
 var exitPatches *gomonkey.Patches
 var mu sync.Mutex
 
 func hookExit(code int) {
-	hasReset := true
-	var cbList []func()
 	mu.Lock()
 	if exitPatches != nil {
 		exitPatches.Reset()
-		hasReset = false
-		exitCallbackListMu.Lock()
-		cbList = exitCallbackList
-		exitCallbackList = nil
-		exitCallbackListMu.Unlock()
+		exitPatches = nil
 	}
 	mu.Unlock()
-	if hasReset {
-		return
-	}
-	for _, cb := range cbList {
-		cb()
-	}
+	Shutdown()
 	os.Exit(code)
 }
-func init() {
-	exitPatches = gomonkey.ApplyFunc(os.Exit, hookExit)
-}
-func Register(callback func()) {
-	exitCallbackListMu.Lock()
-	exitCallbackList = append(exitCallbackList, callback)
-	exitCallbackListMu.Unlock()
+
+// PatchOsExit makes os.Exit run Shutdown first, it is a fallback for code
+// which does not call Exit, and does not work where os.Exit gets inlined or
+// text pages cannot be made writable
+func PatchOsExit() error {
+	mu.Lock()
+	defer mu.Unlock()
+	if exitPatches == nil {
+		exitPatches = gomonkey.ApplyFunc(os.Exit, hookExit)
+	}
+	return nil
 }
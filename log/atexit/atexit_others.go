@@ -0,0 +1,10 @@
+//go:build !linux
+
+package atexit
+
+import "errors"
+
+// PatchOsExit is only supported on linux
+func PatchOsExit() error {
+	return errors.New("os.Exit patch not supported")
+}
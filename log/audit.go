@@ -0,0 +1,360 @@
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// every line of an audit file ends with auditSuffix and the hex hash of the
+// previous hash, a newline and the line
+const auditSuffix = " #h="
+
+// auditSeal is the last line of a file closed cleanly
+const auditSeal = "#audit-seal"
+
+// auditStart followed by the hash the chain goes on from, "-" for none,
+// is the first line of every audit file, so a file can still be verified
+// once the files before it are removed, and the first line of every run
+// appending to an existing file
+const auditStart = "#audit-start "
+
+const auditHashLen = sha256.Size * 2
+
+type auditChain struct {
+	key  []byte
+	prev string
+	undo string
+}
+
+func (c *auditChain) sum(prev, line string) string {
+	var h hash.Hash
+	if len(c.key) > 0 {
+		h = hmac.New(sha256.New, c.key)
+	} else {
+		h = sha256.New()
+	}
+	h.Write([]byte(prev))
+	h.Write([]byte{'\n'})
+	h.Write([]byte(line))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// apply appends the chained hash to every line of buf
+func (c *auditChain) apply(buf string) string {
+	c.undo = c.prev
+	var b strings.Builder
+	b.Grow(len(buf) + (auditHashLen+len(auditSuffix))*(strings.Count(buf, "\n")+1))
+	for len(buf) > 0 {
+		i := strings.IndexByte(buf, '\n')
+		line := buf
+		if i >= 0 {
+			line, buf = buf[:i], buf[i+1:]
+		} else {
+			buf = ""
+		}
+		c.prev = c.sum(c.prev, line)
+		b.WriteString(line)
+		b.WriteString(auditSuffix)
+		b.WriteString(c.prev)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// rollback forgets the last apply, whose lines were not written
+func (c *auditChain) rollback() {
+	c.prev = c.undo
+}
+
+// EnableAudit chains every line written by l to the previous one with a
+// sha256 hash, an HMAC one when key is set, see VerifyAudit. Without a key
+// anyone able to rewrite the files can recompute the chain. The chain goes on
+// from the last file of a previous run. Lines dropped by a full buffer or
+// once the segments are used up are not in the chain, a chained marker line
+// counting them is. It must be called before the first write.
+func (l *FileLogger) EnableAudit(key []byte) error {
+	prev, err := lastAuditHash(l.dir, l.name)
+	if err != nil {
+		return err
+	}
+	l.chain = &auditChain{key: key, prev: prev}
+	return nil
+}
+
+// startAudit writes the chain start line at the top of a new file, and when a
+// run reopens a file, after cutting off the partial line a crash may have
+// left, which would otherwise run into the next line
+func (l *FileLogger) startAudit() {
+	if l.chain == nil {
+		return
+	}
+	if size := atomic.LoadInt64(&l.fileSize); size > 0 {
+		tail, err := partialTail(l.f.Name(), size)
+		if err == nil && tail > 0 {
+			fmt.Printf("audit file %s ends with a partial line, %d bytes cut\n", l.f.Name(), tail)
+			err = l.f.Truncate(size - tail)
+			if err == nil {
+				atomic.StoreInt64(&l.fileSize, size-tail)
+			}
+		}
+		if err != nil {
+			println(fmt.Sprintf("err %v,%s", err, time.Now().Format("2006-01-02 15:04:05.0000")))
+		}
+	}
+	from := l.chain.prev
+	if from == "" {
+		from = "-"
+	}
+	n, err := l.f.WriteString(l.chain.apply(auditStart + from + "\n"))
+	l.addWritten(n)
+	if err != nil {
+		println(fmt.Sprintf("err %v,%s", err, time.Now().Format("2006-01-02 15:04:05.0000")))
+		l.rollbackAudit(n)
+	}
+}
+
+// partialTail returns the length of what follows the last newline of path
+func partialTail(path string, size int64) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	buf := make([]byte, 64*1024)
+	for end := size; end > 0; {
+		start := end - int64(len(buf))
+		if start < 0 {
+			start = 0
+		}
+		n, err := f.ReadAt(buf[:end-start], start)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			return size - start - int64(i) - 1, nil
+		}
+		end = start
+	}
+	return size, nil
+}
+
+// chainStart returns the hash recorded by a start line
+func chainStart(text string) (string, bool) {
+	if !strings.HasPrefix(text, auditStart) {
+		return "", false
+	}
+	from := text[len(auditStart):]
+	if from == "-" {
+		from = ""
+	}
+	return from, true
+}
+
+// sealAudit marks the end of the chain on close, also past the segment cap
+func (l *FileLogger) sealAudit() error {
+	if l.chain == nil || l.f == nil {
+		return nil
+	}
+	err := l.writePastCap(auditSeal + "\n")
+	if err != nil {
+		return err
+	}
+	return l.f.Sync()
+}
+
+var auditFileRe = regexp.MustCompile(`(\d{10})(?:\.(\d+))?\.log(\.gz)?$`)
+
+// AuditFiles returns the files of logger name in dir in write order
+func AuditFiles(dir, name string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type file struct {
+		path string
+		hour string
+		seq  int
+	}
+	var files []file
+	for _, e := range entries {
+		m := auditFileRe.FindStringSubmatchIndex(e.Name())
+		if e.IsDir() || m == nil || e.Name()[:m[0]] != name {
+			continue
+		}
+		sub := auditFileRe.FindStringSubmatch(e.Name())
+		seq, _ := strconv.Atoi(sub[2])
+		files = append(files, file{path: filepath.Join(dir, e.Name()), hour: sub[1], seq: seq})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].hour != files[j].hour {
+			return files[i].hour < files[j].hour
+		}
+		return files[i].seq < files[j].seq
+	})
+	paths := make([]string, 0, len(files))
+	for _, f := range files {
+		paths = append(paths, f.path)
+	}
+	return paths, nil
+}
+
+func lastAuditHash(dir, name string) (string, error) {
+	paths, err := AuditFiles(dir, name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	for i := len(paths) - 1; i >= 0; i-- {
+		var last string
+		err = scanLines(paths[i], func(line string, partial bool) error {
+			if _, h, ok := splitAuditLine(line); ok && !partial {
+				last = h
+			}
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+		if last != "" {
+			return last, nil
+		}
+	}
+	return "", nil
+}
+
+func scanLines(path string, fn func(line string, partial bool) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		z, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = z.Close()
+		}()
+		r = z
+	}
+	br := bufio.NewReaderSize(r, 64*1024)
+	for {
+		line, err := br.ReadString('\n')
+		if len(line) > 0 {
+			partial := !strings.HasSuffix(line, "\n")
+			if e := fn(strings.TrimSuffix(line, "\n"), partial); e != nil {
+				return e
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func splitAuditLine(line string) (text, h string, ok bool) {
+	i := len(line) - auditHashLen - len(auditSuffix)
+	if i < 0 || line[i:i+len(auditSuffix)] != auditSuffix {
+		return line, "", false
+	}
+	return line[:i], line[i+len(auditSuffix):], true
+}
+
+// AuditError locates the first line of audit files which fails verification
+type AuditError struct {
+	Path   string
+	Line   int
+	Reason string
+}
+
+func (e *AuditError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.Path, e.Line, e.Reason)
+}
+
+type AuditReport struct {
+	Lines int
+	// hash of the last line, which a later run can be checked against
+	Last string
+	// whether the last file ends with the seal written on close, a file not
+	// sealed was truncated or its process died
+	Sealed bool
+}
+
+var errAuditEmpty = errors.New("no audit lines")
+
+// VerifyAudit checks the chain of audit files given in write order, e.g. by
+// AuditFiles, with the key given to EnableAudit. Every line is checked, the
+// first one against prev, the hash preceding it, "" for the start of a
+// chain. When prev is empty and the first file starts with a chain start
+// line, the chain is trusted to go on from the hash recorded there, which
+// lets the files left by retention be verified.
+// It fails with an *AuditError on the first line which was altered, inserted
+// or removed, and on a partial last line.
+func VerifyAudit(key []byte, prev string, paths ...string) (*AuditReport, error) {
+	c := &auditChain{key: key, prev: prev}
+	rep := &AuditReport{}
+	for _, p := range paths {
+		n := 0
+		var lastText string
+		err := scanLines(p, func(line string, partial bool) error {
+			n++
+			if partial {
+				return &AuditError{Path: p, Line: n, Reason: "partial line, file truncated"}
+			}
+			text, h, ok := splitAuditLine(line)
+			if !ok {
+				return &AuditError{Path: p, Line: n, Reason: "line without hash"}
+			}
+			if from, ok := chainStart(text); ok {
+				if rep.Lines == 0 && prev == "" {
+					c.prev = from
+				} else if from != c.prev {
+					return &AuditError{Path: p, Line: n, Reason: "chain start does not follow the previous line"}
+				}
+			}
+			if c.sum(c.prev, text) != h {
+				return &AuditError{Path: p, Line: n, Reason: "hash mismatch, line altered, inserted or removed"}
+			}
+			c.prev = h
+			lastText = text
+			rep.Lines++
+			return nil
+		})
+		if err != nil {
+			return rep, err
+		}
+		rep.Sealed = lastText == auditSeal
+	}
+	if rep.Lines == 0 {
+		return rep, errAuditEmpty
+	}
+	rep.Last = c.prev
+	return rep, nil
+}
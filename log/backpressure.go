@@ -0,0 +1,294 @@
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Backpressure decides what FileLogger.Write does when the buffer channel is full
+type Backpressure int
+
+const (
+	// the line being written is dropped, the default
+	DropNewest Backpressure = iota
+	// the oldest buffered line is dropped to make room
+	DropOldest
+	// the caller waits up to the block timeout, then the line is dropped
+	Block
+	// the line is appended to an overflow file next to the log, which is
+	// copied into the log once the buffer drains, so such lines come late
+	Spill
+)
+
+const defaultBlockTimeout = 100 * time.Millisecond
+
+// the marker line is written once no line was dropped for this long
+const lostMarkerDelay = time.Second
+
+var (
+	errBufferFull = errors.New("buffer channel full")
+	errFileFull   = errors.New("file has full")
+)
+
+func (p Backpressure) String() string {
+	switch p {
+	case DropNewest:
+		return "drop_newest"
+	case DropOldest:
+		return "drop_oldest"
+	case Block:
+		return "block"
+	case Spill:
+		return "spill"
+	}
+	return fmt.Sprintf("backpressure(%d)", int(p))
+}
+
+// SetBackpressure selects what happens when the buffer is full, blockTimeout
+// is only used by Block, 0 means defaultBlockTimeout
+func (l *FileLogger) SetBackpressure(p Backpressure, blockTimeout time.Duration) {
+	if blockTimeout <= 0 {
+		blockTimeout = defaultBlockTimeout
+	}
+	atomic.StoreInt64(&l.blockTimeout, int64(blockTimeout))
+	atomic.StoreInt32(&l.backpressure, int32(p))
+}
+
+// SetBackpressure applies p to the main file logger
+func SetBackpressure(p Backpressure, blockTimeout time.Duration) error {
+	l, ok := logger.(*FileLogger)
+	if !ok {
+		return errors.New("file logger not init")
+	}
+	l.SetBackpressure(p, blockTimeout)
+	return nil
+}
+
+// enqueueFull is called by Write when the buffer is full
+func (l *FileLogger) enqueueFull(buf string) error {
+	switch Backpressure(atomic.LoadInt32(&l.backpressure)) {
+	case DropOldest:
+		select {
+		case old := <-l.bufChan:
+			l.drop(old)
+		default:
+		}
+		select {
+		case l.bufChan <- buf:
+			return nil
+		default:
+		}
+	case Block:
+		t := time.NewTimer(time.Duration(atomic.LoadInt64(&l.blockTimeout)))
+		defer t.Stop()
+		select {
+		case l.bufChan <- buf:
+			return nil
+		case <-t.C:
+		}
+	case Spill:
+		err := l.spill(buf)
+		if err == nil {
+			return nil
+		}
+		fmt.Printf("spill log fail, err %s\n", err)
+	}
+	l.drop(buf)
+	return errBufferFull
+}
+
+func (l *FileLogger) drop(buf string) {
+	l.dropN(1, len(buf))
+}
+
+func (l *FileLogger) dropN(lines, size int) {
+	atomic.AddUint64(&l.droppedLines, uint64(lines))
+	atomic.AddUint64(&l.droppedBytes, uint64(size))
+	atomic.AddUint64(&l.lostLines, uint64(lines))
+	atomic.AddUint64(&l.lostBytes, uint64(size))
+	atomic.StoreInt64(&l.lastDrop, time.Now().UnixNano())
+}
+
+// the overflow file is renamed with this suffix while it is copied into the
+// log, it is removed once copied
+const drainingSuffix = ".draining"
+
+func (l *FileLogger) spillPath() string {
+	return l.base + ".overflow"
+}
+
+func (l *FileLogger) spill(buf string) error {
+	l.spillMu.Lock()
+	defer l.spillMu.Unlock()
+	if l.spillFile == nil {
+		// never truncated, lines of a crashed run are replayed by recoverSpill
+		f, err := os.OpenFile(l.spillPath(), os.O_CREATE|os.O_APPEND|os.O_RDWR, 0644)
+		if err != nil {
+			return err
+		}
+		l.spillFile = f
+	}
+	_, err := l.spillFile.WriteString(buf)
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt32(&l.spilled, 1)
+	return nil
+}
+
+// drainSpill copies the overflow file into the log, it runs on the flush worker
+func (l *FileLogger) drainSpill() {
+	if atomic.LoadInt32(&l.spilled) == 0 {
+		return
+	}
+	l.spillMu.Lock()
+	f := l.spillFile
+	l.spillFile = nil
+	atomic.StoreInt32(&l.spilled, 0)
+	var draining string
+	if f != nil {
+		// still readable through f, the next spill starts a new file
+		draining = f.Name() + drainingSuffix
+		err := os.Rename(f.Name(), draining)
+		if err != nil {
+			fmt.Printf("rename spilled log fail, err %s\n", err)
+			draining = ""
+		}
+	}
+	l.spillMu.Unlock()
+	if f == nil {
+		return
+	}
+	defer func() {
+		_ = f.Close()
+		if draining != "" {
+			_ = os.Remove(draining)
+		}
+	}()
+	_, err := f.Seek(0, io.SeekStart)
+	if err != nil {
+		fmt.Printf("read spilled log fail, err %s\n", err)
+		return
+	}
+	r := bufio.NewReaderSize(f, 64*1024)
+	for {
+		chunk, err := readLines(r, 2*1024*1024)
+		if len(chunk) > 0 {
+			_ = l.realWrite(chunk)
+		}
+		if err != nil {
+			if err != io.EOF {
+				fmt.Printf("read spilled log fail, err %s\n", err)
+			}
+			return
+		}
+	}
+}
+
+// recoverSpill hands the overflow left by a run which ended before copying it
+// to the worker, the lines come late, those a crash caught mid-copy twice
+func (l *FileLogger) recoverSpill() {
+	path := l.spillPath()
+	if _, err := os.Stat(path + drainingSuffix); err == nil {
+		// older than any overflow spilled after it
+		err = appendFile(path+drainingSuffix, path)
+		if err == nil {
+			err = os.Rename(path+drainingSuffix, path)
+		}
+		if err != nil {
+			fmt.Printf("recover spilled log fail, err %s\n", err)
+			return
+		}
+	}
+	st, err := os.Stat(path)
+	if err != nil || st.Size() == 0 {
+		return
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_RDWR, 0644)
+	if err != nil {
+		fmt.Printf("recover spilled log fail, err %s\n", err)
+		return
+	}
+	l.spillFile = f
+	atomic.StoreInt32(&l.spilled, 1)
+}
+
+// appendFile appends src to dst and removes src, a missing src is no error
+func appendFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	out, err := os.OpenFile(dst, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if e := out.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// readLines reads whole lines until about max bytes
+func readLines(r *bufio.Reader, max int) (string, error) {
+	var b []byte
+	for len(b) < max {
+		line, err := r.ReadBytes('\n')
+		b = append(b, line...)
+		if err != nil {
+			return string(b), err
+		}
+	}
+	return string(b), nil
+}
+
+// writeLostMarker logs how many lines were dropped since the last marker,
+// it runs on the flush worker once the buffer has drained, force skips the
+// wait for lostMarkerDelay. With an audit chain the marker is written past
+// the segment cap, so the loss is in the chain
+func (l *FileLogger) writeLostMarker(force bool) {
+	if atomic.LoadUint64(&l.lostLines) == 0 {
+		return
+	}
+	// the marker waits until the file has room again
+	if l.chain == nil && atomic.LoadInt32(&l.full) == 1 {
+		return
+	}
+	if !force && time.Since(time.Unix(0, atomic.LoadInt64(&l.lastDrop))) < lostMarkerDelay {
+		return
+	}
+	n := atomic.SwapUint64(&l.lostLines, 0)
+	if n == 0 {
+		return
+	}
+	size := atomic.SwapUint64(&l.lostBytes, 0)
+	r := newRecord(nil, nil, WarnLevel, []Field{F("lost_lines", n), F("lost_bytes", size)},
+		fmt.Sprintf("log buffer or file was full, %d lines (%d bytes) lost", n, size), 1)
+	e := l.encoder
+	if e == nil {
+		e = defaultEncoder
+	}
+	var b bytes.Buffer
+	e.Encode(&b, r)
+	if l.chain != nil {
+		_ = l.writePastCap(b.String())
+		return
+	}
+	_ = l.realWrite(b.String())
+}
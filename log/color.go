@@ -0,0 +1,57 @@
+package log
+
+import (
+	"io"
+	"os"
+	"strings"
+)
+
+// colorOverride reads NO_COLOR and FORCE_COLOR, set is false when neither decides
+func colorOverride() (on bool, set bool) {
+	if os.Getenv("NO_COLOR") != "" {
+		return false, true
+	}
+	if v := os.Getenv("FORCE_COLOR"); v != "" {
+		return v != "0" && !strings.EqualFold(v, "false"), true
+	}
+	return false, false
+}
+
+// ColorEnabled reports whether text written to w is coloured: NO_COLOR turns
+// colour off and FORCE_COLOR on, otherwise only terminals get colour
+func ColorEnabled(w io.Writer) bool {
+	if on, set := colorOverride(); set {
+		return on
+	}
+	return isTerminal(w)
+}
+
+// isTerminal asks the tty driver, a character device such as /dev/null is not a terminal
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	return isatty(f)
+}
+
+// outputColor is the colour decision for out, files, network and syslog never get colour
+func outputColor(out ILogger) bool {
+	if w, ok := out.(*WriterLogger); ok {
+		return ColorEnabled(w.w)
+	}
+	return false
+}
+
+// stdoutColor is the decision for stdout, used by Red, Green... and the stdout echo
+var stdoutColor = ColorEnabled(os.Stdout)
+
+var plainEncoder = &TextEncoder{}
+var colorEncoder = &TextEncoder{Color: true}
+
+func textEncoder(color bool) Encoder {
+	if color {
+		return colorEncoder
+	}
+	return plainEncoder
+}
@@ -0,0 +1,151 @@
+package log
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+type ctxKey int
+
+const (
+	reqIdCtxKey ctxKey = iota
+	traceCtxKey
+)
+
+const traceparentHeader = "traceparent"
+
+// TraceContext is the part of a W3C traceparent header worth logging
+type TraceContext struct {
+	TraceID string
+	SpanID  string
+	Sampled bool
+}
+
+// WithRequestID returns a copy of ctx carrying the request id logged by the *Ctx functions
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, reqIdCtxKey, id)
+}
+
+func RequestIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(reqIdCtxKey).(string)
+	return id
+}
+
+// WithTraceparent returns a copy of ctx carrying the trace of a W3C traceparent
+// header, ctx is returned unchanged if the header is malformed
+func WithTraceparent(ctx context.Context, header string) context.Context {
+	tc, ok := ParseTraceparent(header)
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, traceCtxKey, tc)
+}
+
+// ParseTraceparent parses version-traceid-spanid-flags, e.g.
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func ParseTraceparent(header string) (TraceContext, bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return TraceContext{}, false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return TraceContext{}, false
+	}
+	for _, p := range parts[:4] {
+		if !isLowerHex(p) {
+			return TraceContext{}, false
+		}
+	}
+	if strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return TraceContext{}, false
+	}
+	return TraceContext{
+		TraceID: parts[1],
+		SpanID:  parts[2],
+		Sampled: (hexVal(parts[3][1]) & 1) == 1,
+	}, true
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func hexVal(c byte) byte {
+	if c >= 'a' {
+		return c - 'a' + 10
+	}
+	return c - '0'
+}
+
+// TraceFromContext returns the trace set by WithTraceparent, or else the one
+// found in the traceparent entry of incoming gRPC metadata
+func TraceFromContext(ctx context.Context) (TraceContext, bool) {
+	if ctx == nil {
+		return TraceContext{}, false
+	}
+	if tc, ok := ctx.Value(traceCtxKey).(TraceContext); ok {
+		return tc, true
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(traceparentHeader); len(v) > 0 {
+			return ParseTraceparent(v[0])
+		}
+	}
+	return TraceContext{}, false
+}
+
+// fillCtx sets the correlation data of r from ctx, falling back to the
+// goroutine bound ctx of SetLogCtx
+func (r *Record) fillCtx(ctx context.Context) {
+	r.Ctx = RequestIDFromContext(ctx)
+	if tc, ok := TraceFromContext(ctx); ok {
+		r.TraceID = tc.TraceID
+		r.SpanID = tc.SpanID
+	}
+	if r.Ctx == "" && EnableLogCtx {
+		r.Ctx = GetLogCtx(r.Gid)
+	}
+}
+
+func DebugCtx(ctx context.Context, args ...interface{}) {
+	if !Default().Enabled(DebugLevel) {
+		return
+	}
+	logItArgs(Default(), ctx, DebugLevel, nil, args...)
+}
+func DebugfCtx(ctx context.Context, template string, args ...interface{}) {
+	if !Default().Enabled(DebugLevel) {
+		return
+	}
+	logItFmt(Default(), ctx, nil, DebugLevel, nil, template, args...)
+}
+func InfoCtx(ctx context.Context, args ...interface{}) {
+	logItArgs(Default(), ctx, InfoLevel, nil, args...)
+}
+func InfofCtx(ctx context.Context, template string, args ...interface{}) {
+	logItFmt(Default(), ctx, nil, InfoLevel, nil, template, args...)
+}
+func WarnCtx(ctx context.Context, args ...interface{}) {
+	logItArgs(Default(), ctx, WarnLevel, nil, args...)
+}
+func WarnfCtx(ctx context.Context, template string, args ...interface{}) {
+	logItFmt(Default(), ctx, nil, WarnLevel, nil, template, args...)
+}
+func ErrorCtx(ctx context.Context, args ...interface{}) {
+	logItArgs(Default(), ctx, ErrorLevel, nil, args...)
+}
+func ErrorfCtx(ctx context.Context, template string, args ...interface{}) {
+	logItFmt(Default(), ctx, nil, ErrorLevel, nil, template, args...)
+}
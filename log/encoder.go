@@ -0,0 +1,205 @@
+package log
+
+import (
+	"bytes"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/easygf/core/json"
+)
+
+// Record is one log line before encoding
+type Record struct {
+	Time   time.Time
+	Level  Level
+	Module string
+	Pid    int
+	Gid    int64
+	// request ctx, see WithRequestID
+	Ctx     string
+	TraceID string
+	SpanID  string
+	// caller package path, file base name, line and function name
+	Pkg    string
+	File   string
+	Line   int
+	Func   string
+	Msg    string
+	Fields []Field
+}
+
+// Caller returns pkg/file.go:line
+func (r *Record) Caller() string {
+	return path.Join(r.Pkg, r.File) + ":" + strconv.Itoa(r.Line)
+}
+
+type Encoder interface {
+	Encode(b *bytes.Buffer, r *Record)
+}
+
+// TextEncoder is the classic human oriented layout, see formatLog
+type TextEncoder struct {
+	Color bool
+}
+
+func (e *TextEncoder) Encode(b *bytes.Buffer, r *Record) {
+	formatLog(b, r, e.Color)
+}
+
+// defaultEncoder is the layout of files, see textEncoder for the colour decision of other outputs
+var defaultEncoder Encoder = &TextEncoder{}
+
+const jsonTimeLayout = "2006-01-02T15:04:05.000000Z07:00"
+
+// reserved keys of the json and logfmt layouts, fields using them get an underscore prefix
+var reservedKeys = map[string]bool{
+	"time": true, "level": true, "mod": true, "pid": true, "gid": true,
+	"ctx": true, "trace_id": true, "span_id": true, "caller": true, "func": true, "msg": true,
+}
+
+func fieldKey(k string) string {
+	if reservedKeys[k] {
+		return "_" + k
+	}
+	return k
+}
+
+// JSONEncoder writes one json object per line
+type JSONEncoder struct{}
+
+func (e *JSONEncoder) Encode(b *bytes.Buffer, r *Record) {
+	b.WriteString(`{"time":"`)
+	b.WriteString(r.Time.Format(jsonTimeLayout))
+	b.WriteString(`","level":"`)
+	b.WriteString(r.Level.String())
+	b.WriteString(`","mod":`)
+	writeJsonString(b, r.Module)
+	b.WriteString(`,"pid":`)
+	b.WriteString(strconv.Itoa(r.Pid))
+	b.WriteString(`,"gid":`)
+	b.WriteString(strconv.FormatInt(r.Gid, 10))
+	if r.Ctx != "" {
+		b.WriteString(`,"ctx":`)
+		writeJsonString(b, r.Ctx)
+	}
+	if r.TraceID != "" {
+		b.WriteString(`,"trace_id":"`)
+		b.WriteString(r.TraceID)
+		b.WriteString(`","span_id":"`)
+		b.WriteString(r.SpanID)
+		b.WriteString(`"`)
+	}
+	b.WriteString(`,"caller":`)
+	writeJsonString(b, r.Caller())
+	b.WriteString(`,"func":`)
+	writeJsonString(b, r.Func)
+	b.WriteString(`,"msg":`)
+	writeJsonString(b, r.Msg)
+	for _, f := range r.Fields {
+		b.WriteString(",")
+		writeJsonString(b, fieldKey(f.Key))
+		b.WriteString(":")
+		writeJsonValue(b, f.Val)
+	}
+	b.WriteString("}\n")
+}
+
+func writeJsonString(b *bytes.Buffer, s string) {
+	j, err := json.Marshal(s)
+	if err != nil {
+		b.WriteString(strconv.Quote(s))
+		return
+	}
+	b.Write(j)
+}
+
+func writeJsonValue(b *bytes.Buffer, v interface{}) {
+	switch x := v.(type) {
+	case error:
+		writeJsonString(b, x.Error())
+		return
+	case time.Duration:
+		writeJsonString(b, x.String())
+		return
+	}
+	j, err := json.Marshal(v)
+	if err != nil {
+		writeJsonString(b, fieldValString(v))
+		return
+	}
+	b.Write(j)
+}
+
+// LogfmtEncoder writes space separated key=value pairs
+type LogfmtEncoder struct{}
+
+func (e *LogfmtEncoder) Encode(b *bytes.Buffer, r *Record) {
+	b.WriteString("time=")
+	b.WriteString(r.Time.Format(jsonTimeLayout))
+	b.WriteString(" level=")
+	b.WriteString(r.Level.String())
+	writeLogfmtPair(b, "mod", r.Module)
+	writeLogfmtPair(b, "pid", strconv.Itoa(r.Pid))
+	writeLogfmtPair(b, "gid", strconv.FormatInt(r.Gid, 10))
+	if r.Ctx != "" {
+		writeLogfmtPair(b, "ctx", r.Ctx)
+	}
+	if r.TraceID != "" {
+		writeLogfmtPair(b, "trace_id", r.TraceID)
+		writeLogfmtPair(b, "span_id", r.SpanID)
+	}
+	writeLogfmtPair(b, "caller", r.Caller())
+	writeLogfmtPair(b, "func", r.Func)
+	writeLogfmtPair(b, "msg", r.Msg)
+	for _, f := range r.Fields {
+		writeLogfmtPair(b, fieldKey(f.Key), fieldValString(f.Val))
+	}
+	b.WriteString("\n")
+}
+
+func writeLogfmtPair(b *bytes.Buffer, k, v string) {
+	b.WriteString(" ")
+	b.WriteString(logfmtKey(k))
+	b.WriteString("=")
+	if v == "" || strings.ContainsAny(v, " =\"\t\r\n") {
+		v = strconv.Quote(v)
+	}
+	b.WriteString(v)
+}
+
+// logfmtKey replaces what would end a key or start its value, spaces, '=',
+// quotes and control characters, with '_', logfmt readers do not unquote keys
+func logfmtKey(k string) string {
+	if k == "" {
+		return "_"
+	}
+	bad := func(r rune) bool {
+		return r <= ' ' || r == '=' || r == '"' || r == 0x7f
+	}
+	if strings.IndexFunc(k, bad) < 0 {
+		return k
+	}
+	return strings.Map(func(r rune) rune {
+		if bad(r) {
+			return '_'
+		}
+		return r
+	}, k)
+}
+
+// EncoderByName maps "text", "color_text", "json" and "logfmt" to an encoder, nil if unknown
+func EncoderByName(name string) Encoder {
+	switch name {
+	case "text":
+		return &TextEncoder{}
+	case "color_text":
+		return &TextEncoder{Color: true}
+	case "json":
+		return &JSONEncoder{}
+	case "logfmt":
+		return &LogfmtEncoder{}
+	}
+	return nil
+}
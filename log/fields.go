@@ -0,0 +1,93 @@
+package log
+
+import (
+	"bytes"
+	"fmt"
+)
+
+type Field struct {
+	Key string
+	Val interface{}
+}
+
+func F(key string, val interface{}) Field {
+	return Field{Key: key, Val: val}
+}
+
+const badKey = "!BADKEY"
+
+// toFields turns alternating keys and values into fields, a Field in
+// the list is taken as is and a dangling value is kept under badKey
+func toFields(kv []interface{}) []Field {
+	if len(kv) == 0 {
+		return nil
+	}
+	fields := make([]Field, 0, (len(kv)+1)/2)
+	for i := 0; i < len(kv); i++ {
+		switch k := kv[i].(type) {
+		case Field:
+			fields = append(fields, k)
+		case string:
+			if i+1 < len(kv) {
+				fields = append(fields, Field{Key: k, Val: kv[i+1]})
+				i++
+			} else {
+				fields = append(fields, Field{Key: badKey, Val: k})
+			}
+		default:
+			fields = append(fields, Field{Key: badKey, Val: k})
+		}
+	}
+	return fields
+}
+
+func joinFields(a, b []Field) []Field {
+	if len(a) == 0 {
+		return b
+	}
+	if len(b) == 0 {
+		return a
+	}
+	out := make([]Field, 0, len(a)+len(b))
+	out = append(out, a...)
+	return append(out, b...)
+}
+
+func fieldValString(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case error:
+		return x.Error()
+	case fmt.Stringer:
+		return x.String()
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
+func appendFields(b *bytes.Buffer, fields []Field) {
+	for _, f := range fields {
+		writeLogfmtPair(b, f.Key, fieldValString(f.Val))
+	}
+}
+
+func With(kv ...interface{}) *Logger {
+	return Default().With(kv...)
+}
+
+func Debugw(msg string, kv ...interface{}) {
+	if !Default().Enabled(DebugLevel) {
+		return
+	}
+	logItFmt(Default(), nil, nil, DebugLevel, toFields(kv), msg)
+}
+func Infow(msg string, kv ...interface{}) {
+	logItFmt(Default(), nil, nil, InfoLevel, toFields(kv), msg)
+}
+func Warnw(msg string, kv ...interface{}) {
+	logItFmt(Default(), nil, nil, WarnLevel, toFields(kv), msg)
+}
+func Errorw(msg string, kv ...interface{}) {
+	logItFmt(Default(), nil, nil, ErrorLevel, toFields(kv), msg)
+}
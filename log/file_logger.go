@@ -2,6 +2,7 @@ package log
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -9,23 +10,49 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const defaultFlushTimeout = 3 * time.Second
+
+var errLoggerClosed = errors.New("logger closed")
+
 type FileLogger struct {
+	// accessed atomically, kept first for 64-bit alignment
+	bytesWritten int64
+	fileSize     int64
+	blockTimeout int64
+	lastDrop     int64
+	droppedLines uint64
+	droppedBytes uint64
+	// dropped since the last marker line
+	lostLines uint64
+	lostBytes uint64
+
 	f                 *os.File
 	base              string
 	curHour           string
 	curHourTs         int64
-	curSecondTs       int64
 	maxFileSize       int64
-	full              bool
+	seq               int
+	maxSegments       int
+	full              int32 // atomic, set by the worker
 	lastCheckFileSize int64
 	bufChan           chan string
-	flushChan         chan bool
-
-	flushWorkerExit   bool
-	flushWorkerExitMu sync.Mutex
+	flushChan         chan flushReq
+	workerDone        chan bool
+	closed            int32
+	encoder           Encoder
+	dir               string
+	name              string
+	curPath           atomic.Value
+	retention         *RetentionManager
+	chain             *auditChain
+	backpressure      int32
+	spillMu           sync.Mutex
+	spillFile         *os.File
+	spilled           int32
 }
 
 func InitFileLog(dir string, name string) error {
@@ -41,14 +68,14 @@ func InitFileLog(dir string, name string) error {
 	return nil
 }
 
-func (l *FileLogger) getFlushWorkerExit() bool {
-	l.flushWorkerExitMu.Lock()
-	res := l.flushWorkerExit
-	l.flushWorkerExitMu.Unlock()
-	return res
+type flushReq struct {
+	done  chan error
+	close bool
 }
 
 func (l *FileLogger) flushWorker() {
+	tick := time.NewTicker(lostMarkerDelay)
+	defer tick.Stop()
 	for {
 		select {
 		case buf := <-l.bufChan:
@@ -66,23 +93,61 @@ func (l *FileLogger) flushWorker() {
 			}
 		OUT:
 			_ = l.realWrite(b.String())
-		case <-l.flushChan:
-			con := true
-			for con {
-				select {
-				case buf := <-l.bufChan:
-					_ = l.realWrite(buf)
-				default:
-					con = false
-					break
+			if len(l.bufChan) == 0 {
+				l.drainSpill()
+				l.writeLostMarker(false)
+			}
+		case <-tick.C:
+			if atomic.LoadInt32(&l.full) == 1 {
+				l.recheckFull()
+			}
+			if len(l.bufChan) == 0 {
+				l.drainSpill()
+				l.writeLostMarker(false)
+			}
+		case req := <-l.flushChan:
+			err := l.drain()
+			if req.close {
+				if e := l.sealAudit(); e != nil && err == nil {
+					err = e
+				}
+				if l.f != nil {
+					closeErr := l.f.Close()
+					if err == nil {
+						err = closeErr
+					}
+					l.f = nil
 				}
+				req.done <- err
+				close(l.workerDone)
+				return
+			}
+			req.done <- err
+		}
+	}
+}
+
+// drain writes every buffered line and fsyncs the file
+func (l *FileLogger) drain() error {
+	var err error
+	for con := true; con; {
+		select {
+		case buf := <-l.bufChan:
+			if e := l.realWrite(buf); e != nil {
+				err = e
 			}
-			l.flushWorkerExitMu.Lock()
-			l.flushWorkerExit = true
-			l.flushWorkerExitMu.Unlock()
-			return
+		default:
+			con = false
+		}
+	}
+	l.drainSpill()
+	l.writeLostMarker(true)
+	if l.f != nil {
+		if e := l.f.Sync(); e != nil && err == nil {
+			err = e
 		}
 	}
+	return err
 }
 
 func (l *FileLogger) Init(dir string, name string) error {
@@ -100,80 +165,220 @@ func (l *FileLogger) Init(dir string, name string) error {
 		}
 	}
 	name = removeSuffixIfMatched(name, ".log")
+	l.dir = dir
+	l.name = name
 	l.base = fmt.Sprintf("%s%s%s", dir, fileSep, name)
 	l.maxFileSize = defaultMaxFileSize
+	l.maxSegments = defaultMaxSegments
 	l.bufChan = make(chan string, 100000)
-	l.flushChan = make(chan bool, 100)
+	l.flushChan = make(chan flushReq)
+	l.workerDone = make(chan bool)
+	l.recoverSpill()
 	go l.flushWorker()
 	return nil
 }
 
+// segmentPath returns name2026101715.log for the first segment of an hour
+// and name2026101715.N.log for the following ones
+func (l *FileLogger) segmentPath(hour string, seq int) string {
+	if seq == 0 {
+		return fmt.Sprintf("%s%s.log", l.base, hour)
+	}
+	return fmt.Sprintf("%s%s.%d.log", l.base, hour, seq)
+}
+
 func (l *FileLogger) needOpen() bool {
-	res := false
 	now := time.Now()
-	if l.f == nil {
-		res = true
-	} else if l.full {
-		ts := now.Unix()
-		if l.curSecondTs+60 < ts {
-			l.curSecondTs = ts
-			res = true
+	ts := now.Unix() / 3600
+	if l.f != nil && ts == l.curHourTs {
+		return false
+	}
+	l.curHourTs = ts
+	l.curHour = now.Format("2006010215")
+	l.seq = l.firstFreeSegment()
+	return true
+}
+
+// firstFreeSegment skips the segments of the current hour already filled or
+// compressed, e.g. by a previous run
+func (l *FileLogger) firstFreeSegment() int {
+	seq := 0
+	for ; seq < l.maxSegments-1; seq++ {
+		p := l.segmentPath(l.curHour, seq)
+		if fileExist(p + ".gz") {
+			continue
 		}
-	} else {
-		ts := now.Unix() / 3600
-		if ts != l.curHourTs {
-			l.curHourTs = ts
-			res = true
+		st, err := os.Stat(p)
+		if err != nil || st.Size() < l.maxFileSize {
+			break
 		}
 	}
-	if res {
-		l.curHour = now.Format("2006010215")
+	return seq
+}
+
+func (l *FileLogger) reloadLimit() {
+	now := time.Now().Unix()
+	if l.lastCheckFileSize+10 >= now {
+		return
+	}
+	l.lastCheckFileSize = now
+	// other processes may append to the same file
+	if l.f != nil {
+		size, err := l.f.Seek(0, io.SeekEnd)
+		if err == nil {
+			atomic.StoreInt64(&l.fileSize, size)
+		}
+	}
+	// get current max
+	dat, err := os.ReadFile(maxLogFileSizeFile)
+	if err == nil && len(dat) > 0 {
+		v, err := strconv.ParseInt(strings.TrimSpace(string(dat)), 10, 64)
+		if err == nil && v > 0 {
+			l.maxFileSize = v
+		}
 	}
-	return res
 }
 
+// checkFull rolls over to the next segment once the current one reaches
+// maxFileSize, the logger is full when the last segment of the hour is too
 func (l *FileLogger) checkFull() {
-	now := time.Now().Unix()
-	last := l.lastCheckFileSize
-	if last+10 < now {
-		f := l.f
-		if f != nil {
-			l.lastCheckFileSize = now
-			size, err := f.Seek(0, io.SeekEnd)
-			if err == nil {
-				// get current max
-				dat, err := os.ReadFile(maxLogFileSizeFile)
-				if err == nil && len(dat) > 0 {
-					v, err := strconv.ParseInt(strings.TrimSpace(string(dat)), 10, 64)
-					if err == nil && v > 0 {
-						l.maxFileSize = v
-					}
-				}
-				if size >= l.maxFileSize {
-					l.full = true
-				} else {
-					l.full = false
-				}
-			}
+	l.reloadLimit()
+	if atomic.LoadInt64(&l.fileSize) < l.maxFileSize {
+		atomic.StoreInt32(&l.full, 0)
+		return
+	}
+	seq := l.seq + 1
+	for seq < l.maxSegments && fileExist(l.segmentPath(l.curHour, seq)+".gz") {
+		seq++
+	}
+	if seq >= l.maxSegments {
+		atomic.StoreInt32(&l.full, 1)
+		return
+	}
+	prev := l.seq
+	l.seq = seq
+	err := l.open()
+	if err != nil {
+		l.seq = prev
+		atomic.StoreInt32(&l.full, 1)
+	}
+}
+
+// recheckFull runs on the worker while write refuses lines, a new hour or a
+// raised limit frees the logger again
+func (l *FileLogger) recheckFull() {
+	if l.needOpen() {
+		if err := l.open(); err != nil {
+			println(fmt.Sprintf("err %v,%s", err, time.Now().Format("2006-01-02 15:04:05.0000")))
 		}
+		return
+	}
+	l.checkFull()
+}
+
+// SetMaxFileSize sets the size of a segment, /etc/brick/max_log_size takes
+// precedence when present, it must be called before the first write
+func (l *FileLogger) SetMaxFileSize(size int64) {
+	if size > 0 {
+		l.maxFileSize = size
+	}
+}
+
+// SetMaxSegments caps the segments written per hour, further lines are dropped
+// until the next hour, it must be called before the first write
+func (l *FileLogger) SetMaxSegments(n int) {
+	if n > 0 {
+		l.maxSegments = n
+	}
+}
+
+// SetEncoder selects the line layout, it must be called before the first write
+func (l *FileLogger) SetEncoder(e Encoder) {
+	l.encoder = e
+}
+
+func (l *FileLogger) WriteRecord(r *Record) error {
+	e := l.encoder
+	if e == nil {
+		e = defaultEncoder
+	}
+	b := getBuffer()
+	defer putBuffer(b)
+	e.Encode(b, r)
+	if log2Stdout {
+		l.echo(r, e, b.String())
 	}
+	return l.write(b.String())
+}
+
+// echo prints a line written to the file on stdout, coloured for terminals
+func (l *FileLogger) echo(r *Record, e Encoder, line string) {
+	if te, ok := e.(*TextEncoder); ok && !te.Color && stdoutColor {
+		b := getBuffer()
+		colorEncoder.Encode(b, r)
+		_, _ = os.Stdout.Write(b.Bytes())
+		putBuffer(b)
+		return
+	}
+	fmt.Print(line)
+}
+
+// SetEncoder sets the line layout of the main file logger
+func SetEncoder(e Encoder) error {
+	l, ok := logger.(*FileLogger)
+	if !ok {
+		return errors.New("file logger not init")
+	}
+	l.SetEncoder(e)
+	return nil
 }
 
 func (l *FileLogger) Write(buf string) error {
 	if log2Stdout {
 		fmt.Print(buf)
 	}
+	return l.write(buf)
+}
+
+func (l *FileLogger) write(buf string) error {
+	if atomic.LoadInt32(&l.closed) == 1 {
+		return errLoggerClosed
+	}
+	if atomic.LoadInt32(&l.full) == 1 {
+		l.drop(buf)
+		return errFileFull
+	}
 	select {
 	case l.bufChan <- buf:
-	// sent
+		return nil
 	default:
-		println("waring: buffer channel full")
-		return errors.New("buffer channel full")
+		return l.enqueueFull(buf)
 	}
-	return nil
 }
 
 func (l *FileLogger) realWrite(buf string) error {
+	if err := l.ensureOpen(); err != nil {
+		return err
+	}
+	l.checkFull()
+	if atomic.LoadInt32(&l.full) == 1 {
+		// lines queued before the file filled up
+		l.dropN(strings.Count(buf, "\n"), len(buf))
+		return errFileFull
+	}
+	return l.writeFile(buf)
+}
+
+// writePastCap writes buf even when the last segment is full, for the few
+// lines an audit chain cannot do without, the lost lines marker and the seal
+func (l *FileLogger) writePastCap(buf string) error {
+	if err := l.ensureOpen(); err != nil {
+		return err
+	}
+	return l.writeFile(buf)
+}
+
+func (l *FileLogger) ensureOpen() error {
 	if l.needOpen() {
 		err := l.open()
 		if err != nil {
@@ -183,52 +388,101 @@ func (l *FileLogger) realWrite(buf string) error {
 	if l.f == nil {
 		return errors.New("file not open")
 	}
-	l.checkFull()
-	if l.full {
-		now := time.Now()
-		if lasTime.Add(time.Minute).Before(now) {
-			lasTime = now
-			// println(fmt.Sprintf("file has full %s", time.Now().Format("2006-01-02 15:04:05.0000")))
-		}
-		return errors.New("file has full")
+	return nil
+}
+
+func (l *FileLogger) writeFile(buf string) error {
+	if l.chain != nil {
+		buf = l.chain.apply(buf)
 	}
 	n, err := l.f.WriteString(buf)
+	l.addWritten(n)
 	if err != nil {
 		println(fmt.Sprintf("err %v,%s", err, time.Now().Format("2006-01-02 15:04:05.0000")))
+		l.rollbackAudit(n)
 		return err
 	}
 	for n < len(buf) {
 		x, err := l.f.WriteString(buf[n:])
+		l.addWritten(x)
+		n += x
 		if err != nil {
 			println(fmt.Sprintf("err %v,%s", err, time.Now().Format("2006-01-02 15:04:05.0000")))
+			l.rollbackAudit(n)
 			return err
 		}
-		n += x
 	}
 	return nil
 }
+
+// rollbackAudit forgets the hashes of a write which did not reach the file
+func (l *FileLogger) rollbackAudit(written int) {
+	if l.chain != nil && written == 0 {
+		l.chain.rollback()
+	}
+}
+func (l *FileLogger) addWritten(n int) {
+	if n > 0 {
+		atomic.AddInt64(&l.bytesWritten, int64(n))
+		atomic.AddInt64(&l.fileSize, int64(n))
+	}
+}
 func (l *FileLogger) Sync() error {
 	if l.f == nil {
 		return errors.New("file not open")
 	}
 	return l.f.Sync()
 }
+
+// Flush waits up to defaultFlushTimeout for the buffered lines to be written, see FlushCtx
 func (l *FileLogger) Flush() {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultFlushTimeout)
+	defer cancel()
+	err := l.FlushCtx(ctx)
+	if err != nil {
+		fmt.Printf("flush log fail, err %s\n", err)
+	}
+}
+
+// FlushCtx returns once every line written before the call is in the file
+// and the file is fsynced, or when ctx is done
+func (l *FileLogger) FlushCtx(ctx context.Context) error {
+	return l.request(ctx, false)
+}
+
+// Close flushes like FlushCtx, then closes the file and stops the worker,
+// later writes fail
+func (l *FileLogger) Close() error {
+	if !atomic.CompareAndSwapInt32(&l.closed, 0, 1) {
+		return errLoggerClosed
+	}
+	if l.retention != nil {
+		l.retention.Stop()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultFlushTimeout)
+	defer cancel()
+	return l.request(ctx, true)
+}
+
+func (l *FileLogger) request(ctx context.Context, close bool) error {
+	req := flushReq{done: make(chan error, 1), close: close}
 	select {
-	case l.flushChan <- true:
-	// sent
-	default:
-		// channel full
+	case l.flushChan <- req:
+	case <-l.workerDone:
+		return errLoggerClosed
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	for i := 0; i < 100; i++ {
-		if l.getFlushWorkerExit() {
-			break
-		}
-		time.Sleep(50 * time.Microsecond)
+	select {
+	case err := <-req.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
+
 func (l *FileLogger) open() error {
-	logPath := fmt.Sprintf("%s%s.log", l.base, l.curHour)
+	logPath := l.segmentPath(l.curHour, l.seq)
 	old := UMask(0)
 	defer UMask(old)
 	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, os.ModePerm)
@@ -238,12 +492,48 @@ func (l *FileLogger) open() error {
 	}
 	oldFile := l.f
 	l.f = f
+	l.curPath.Store(logPath)
+	if st, err := f.Stat(); err == nil {
+		atomic.StoreInt64(&l.fileSize, st.Size())
+	}
 	if oldFile != nil {
 		err = oldFile.Close()
 		if err != nil {
 			fmt.Printf("close old file fail, name %s, err %s\n", oldFile.Name(), err)
 		}
+		if l.retention != nil {
+			l.retention.Rotated(oldFile.Name())
+		}
+	}
+	atomic.StoreInt32(&l.full, 0)
+	l.startAudit()
+	return nil
+}
+
+func fileExist(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// CurrentPath returns the file being written, empty before the first write
+func (l *FileLogger) CurrentPath() string {
+	p, _ := l.curPath.Load().(string)
+	return p
+}
+
+// SetRetention starts deleting and compressing the old files of l, it must be called once
+func (l *FileLogger) SetRetention(p RetentionPolicy) {
+	m := NewRetentionManager(l.dir, l.name, p, l.CurrentPath)
+	l.retention = m
+	m.Start()
+}
+
+// SetRetention applies p to the main file logger
+func SetRetention(p RetentionPolicy) error {
+	l, ok := logger.(*FileLogger)
+	if !ok {
+		return errors.New("file logger not init")
 	}
-	l.full = false
+	l.SetRetention(p)
 	return nil
 }
@@ -0,0 +1,124 @@
+package log
+
+import (
+	"bytes"
+	"path"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// buffers above this size are not pooled so one huge line does not pin memory
+const maxPooledBuffer = 64 * 1024
+
+var bufPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+func getBuffer() *bytes.Buffer {
+	b := bufPool.Get().(*bytes.Buffer)
+	b.Reset()
+	return b
+}
+
+func putBuffer(b *bytes.Buffer) {
+	if b.Cap() <= maxPooledBuffer {
+		bufPool.Put(b)
+	}
+}
+
+// callerInfo is what a program counter resolves to, it never changes
+type callerInfo struct {
+	pkg  string
+	fn   string
+	file string
+	line int
+}
+
+var callerCache sync.Map // uintptr -> *callerInfo
+
+// lookupCaller is runtime.Caller(skip) from the function calling it, with
+// the names split and cached by pc
+func lookupCaller(skip int) *callerInfo {
+	var pcs [1]uintptr
+	if runtime.Callers(skip+2, pcs[:]) == 0 {
+		return &callerInfo{file: "."}
+	}
+	if c, ok := callerCache.Load(pcs[0]); ok {
+		return c.(*callerInfo)
+	}
+	f, _ := runtime.CallersFrames(pcs[:]).Next()
+	c := &callerInfo{file: path.Base(f.File), line: f.Line}
+	c.pkg, c.fn = getPackageName(f.Function)
+	callerCache.Store(pcs[0], c)
+	return c
+}
+
+type secondCache struct {
+	sec int64
+	s   string
+}
+
+var timeCache atomic.Value // *secondCache
+
+// formatTime returns t as 01-02T15:04:05, formatted once per second
+func formatTime(t time.Time) string {
+	sec := t.Unix()
+	if c, ok := timeCache.Load().(*secondCache); ok && c.sec == sec {
+		return c.s
+	}
+	var a [14]byte
+	_, month, day := t.Date()
+	hour, min, s := t.Clock()
+	put2(a[0:], int(month))
+	a[2] = '-'
+	put2(a[3:], day)
+	a[5] = 'T'
+	put2(a[6:], hour)
+	a[8] = ':'
+	put2(a[9:], min)
+	a[11] = ':'
+	put2(a[12:], s)
+	str := string(a[:])
+	timeCache.Store(&secondCache{sec: sec, s: str})
+	return str
+}
+
+func put2(a []byte, n int) {
+	a[0] = byte('0' + n/10)
+	a[1] = byte('0' + n%10)
+}
+
+// writeInt writes n in decimal
+func writeInt(b *bytes.Buffer, n int64) {
+	var a [20]byte
+	i := len(a)
+	u := uint64(n)
+	if n < 0 {
+		u = uint64(-n)
+	}
+	for u >= 10 {
+		i--
+		a[i] = byte('0' + u%10)
+		u /= 10
+	}
+	i--
+	a[i] = byte('0' + u)
+	if n < 0 {
+		b.WriteByte('-')
+	}
+	b.Write(a[i:])
+}
+
+// writeFixed writes n zero padded to width digits
+func writeFixed(b *bytes.Buffer, n int, width int) {
+	var a [20]byte
+	for i := width - 1; i >= 0; i-- {
+		a[i] = byte('0' + n%10)
+		n /= 10
+	}
+	b.Write(a[:width])
+}
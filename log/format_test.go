@@ -0,0 +1,219 @@
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"path"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// legacyFormatLog is formatLog before the pooled buffers and hand-written
+// number formatting, kept to compare output and speed
+func legacyFormatLog(b *bytes.Buffer, r *Record, color bool) {
+	b.WriteString(r.Module)
+	b.WriteString(fmt.Sprintf("(%d,%d) ", r.Pid, r.Gid))
+	b.WriteString(r.Time.Format("01-02T15:04:05"))
+	b.WriteString(fmt.Sprintf(".%04d ", r.Time.Nanosecond()/100000))
+	if r.Ctx != "" {
+		b.WriteString("<")
+		b.WriteString(r.Ctx)
+		b.WriteString("> ")
+	}
+	if color {
+		b.WriteString(r.Level.Color())
+	}
+	b.WriteString(r.Level.ShortString())
+	b.WriteString(r.Caller())
+	b.WriteString(":")
+	b.WriteString(r.Func)
+	if color {
+		b.WriteString(colorEnd)
+	}
+	b.WriteString(" ")
+	b.WriteString(r.Msg)
+	appendFields(b, r.Fields)
+	if r.TraceID != "" {
+		writeLogfmtPair(b, "trace_id", r.TraceID)
+		writeLogfmtPair(b, "span_id", r.SpanID)
+	}
+	b.WriteString("\n")
+}
+
+// legacyCaller is the runtime.Caller and runtime.FuncForPC lookup lookupCaller replaced
+func legacyCaller(skip int) (string, string, string, int) {
+	pc, file, line, ok := runtime.Caller(skip + 1)
+	var name string
+	if ok {
+		name = runtime.FuncForPC(pc).Name()
+	}
+	pkg, fn := getPackageName(name)
+	return pkg, fn, path.Base(file), line
+}
+
+func testRecords() []*Record {
+	t := time.Date(2026, 12, 31, 23, 59, 58, 987654321, time.Local)
+	return []*Record{
+		{Time: t, Level: InfoLevel, Module: "svc", Pid: 1234, Gid: 56, Pkg: "github.com/easygf/core/log", File: "log.go", Line: 42, Func: "Infof", Msg: "hello"},
+		{Time: t.Add(time.Second), Level: ErrorLevel, Module: "svc", Pid: 1, Gid: -1, Ctx: "req-1", File: "main.go", Line: 7, Func: "main", Msg: "a b",
+			Fields: []Field{F("uid", 42), F("name", "bob smith")}},
+		{Time: t.Add(-time.Hour * 24 * 200), Level: DebugLevel, Module: "m", Pid: 0, Gid: 0, Pkg: "p", File: "f.go", Line: 0, Func: "(*T).m", Msg: "x",
+			TraceID: "0af7651916cd43dd8448eb211c80319c", SpanID: "b7ad6b7169203331"},
+	}
+}
+
+func TestFormatLogMatchesLegacy(t *testing.T) {
+	for _, r := range testRecords() {
+		for _, color := range []bool{false, true} {
+			var got, want bytes.Buffer
+			formatLog(&got, r, color)
+			legacyFormatLog(&want, r, color)
+			if got.String() != want.String() {
+				t.Errorf("color %v\n got %q\nwant %q", color, got.String(), want.String())
+			}
+		}
+	}
+}
+
+func TestWriteInt(t *testing.T) {
+	for _, n := range []int64{0, 1, -1, 9, 10, -10, 1234567890, math.MaxInt64, math.MinInt64} {
+		var b bytes.Buffer
+		writeInt(&b, n)
+		if want := strconv.FormatInt(n, 10); b.String() != want {
+			t.Errorf("%d: got %q want %q", n, b.String(), want)
+		}
+	}
+}
+
+func TestWriteFixed(t *testing.T) {
+	for _, n := range []int{0, 7, 42, 999, 1234, 9999} {
+		var b bytes.Buffer
+		writeFixed(&b, n, 4)
+		if want := fmt.Sprintf("%04d", n); b.String() != want {
+			t.Errorf("%d: got %q want %q", n, b.String(), want)
+		}
+	}
+}
+
+func TestLogfmtKey(t *testing.T) {
+	for k, want := range map[string]string{
+		"uid": "uid", "": "_", "a b": "a_b", "a=b": "a_b", `a"b`: "a_b", "a\nb\tc": "a_b_c", "名字": "名字",
+	} {
+		var b bytes.Buffer
+		writeLogfmtPair(&b, k, "v")
+		if got := b.String(); got != " "+want+"=v" {
+			t.Errorf("%q: got %q want %q", k, got, " "+want+"=v")
+		}
+	}
+}
+
+func TestFormatTime(t *testing.T) {
+	base := time.Date(2026, 1, 2, 3, 4, 5, 0, time.Local)
+	for _, d := range []time.Duration{0, 500 * time.Millisecond, time.Second, time.Hour * 20, time.Hour * 24 * 300} {
+		tm := base.Add(d)
+		// twice, the second call is served by the cache
+		for i := 0; i < 2; i++ {
+			if got, want := formatTime(tm), tm.Format("01-02T15:04:05"); got != want {
+				t.Errorf("%s: got %q want %q", tm, got, want)
+			}
+		}
+	}
+}
+
+func TestLookupCaller(t *testing.T) {
+	for i := 0; i < 2; i++ {
+		c := lookupCaller(0)
+		pkg, fn, file, line := legacyCaller(0)
+		if c.pkg != pkg || c.fn != fn || c.file != file || c.line != line-1 {
+			t.Errorf("got %+v want %s %s %s:%d", *c, pkg, fn, file, line-1)
+		}
+	}
+}
+
+type discardLogger struct{}
+
+func (discardLogger) Write(buf string) error { return nil }
+func (discardLogger) Sync() error            { return nil }
+func (discardLogger) Flush()                 {}
+
+func BenchmarkFormatLog(b *testing.B) {
+	r := testRecords()[1]
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf := getBuffer()
+		formatLog(buf, r, false)
+		putBuffer(buf)
+	}
+}
+
+func BenchmarkFormatLogLegacy(b *testing.B) {
+	r := testRecords()[1]
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var buf bytes.Buffer
+		legacyFormatLog(&buf, r, false)
+	}
+}
+
+func BenchmarkLookupCaller(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = lookupCaller(0)
+	}
+}
+
+func BenchmarkLookupCallerLegacy(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _, _, _ = legacyCaller(0)
+	}
+}
+
+func BenchmarkFormatTime(b *testing.B) {
+	t := time.Now()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = formatTime(t)
+	}
+}
+
+func BenchmarkFormatTimeLegacy(b *testing.B) {
+	t := time.Now()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = t.Format("01-02T15:04:05")
+	}
+}
+
+func BenchmarkWriteInt(b *testing.B) {
+	var buf bytes.Buffer
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		writeInt(&buf, int64(i))
+	}
+}
+
+func BenchmarkWriteIntSprintf(b *testing.B) {
+	var buf bytes.Buffer
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		buf.WriteString(fmt.Sprintf("%d", i))
+	}
+}
+
+func BenchmarkInfof(b *testing.B) {
+	prev := logger
+	logger = discardLogger{}
+	defer func() {
+		logger = prev
+	}()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Infof("request %d done in %s", i, "3ms")
+	}
+}
@@ -0,0 +1,59 @@
+package log
+
+import (
+	"errors"
+	"sync"
+)
+
+const defaultImportantDir = "/home/brick/log"
+
+// ImportantOptions configures the channel written by Important
+type ImportantOptions struct {
+	// defaultImportantDir when empty
+	Dir  string
+	Name string
+	// nil keeps every file
+	Retention *RetentionPolicy
+	// chain the lines with hashes, see FileLogger.EnableAudit and VerifyAudit
+	Audit    bool
+	AuditKey []byte
+}
+
+var importantMu sync.Mutex
+
+// InitImportant sets up the important channel, it must be called before the
+// first Important, which otherwise uses the defaults
+func InitImportant(o ImportantOptions) error {
+	importantMu.Lock()
+	defer importantMu.Unlock()
+	if importantLogger() != nil {
+		return errors.New("important logger has init")
+	}
+	return initImportantLocked(o)
+}
+
+func initImportantLocked(o ImportantOptions) error {
+	if o.Dir == "" {
+		o.Dir = defaultImportantDir
+	}
+	l := &FileLogger{}
+	err := l.Init(o.Dir, o.Name)
+	if err != nil {
+		return err
+	}
+	if o.Audit {
+		// lines are dropped before they are chained, VerifyAudit would not
+		// notice the loss, so an audit trail waits for room instead
+		l.SetBackpressure(Block, defaultFlushTimeout)
+		err = l.EnableAudit(o.AuditKey)
+		if err != nil {
+			_ = l.Close()
+			return err
+		}
+	}
+	if o.Retention != nil {
+		l.SetRetention(*o.Retention)
+	}
+	loggerImportant.Store(l)
+	return nil
+}
@@ -0,0 +1,94 @@
+package log
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type levelOverride struct {
+	prefix string
+	level  Level
+}
+
+// overrides is sorted by descending prefix length so the first match is the most specific
+var overrides atomic.Value // []levelOverride
+var overridesMu sync.Mutex
+
+// minOverrideLevel is the lowest level of any override, accessed atomically
+var minOverrideLevel = int32(ImportantLevel + 1)
+
+func getOverrides() []levelOverride {
+	o, _ := overrides.Load().([]levelOverride)
+	return o
+}
+
+func updateOverrides(m map[string]Level) {
+	list := make([]levelOverride, 0, len(m))
+	min := ImportantLevel + 1
+	for p, l := range m {
+		list = append(list, levelOverride{prefix: p, level: l})
+		if l < min {
+			min = l
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return len(list[i].prefix) > len(list[j].prefix)
+	})
+	overrides.Store(list)
+	atomic.StoreInt32(&minOverrideLevel, int32(min))
+}
+
+// SetPackageLevel sets the level of the callers whose function name, as
+// reported by runtime.FuncForPC, starts with prefix, e.g.
+// "github.com/easygf/core/config" or "github.com/easygf/core/config.(*Config).Get".
+// The longest matching prefix wins, other callers use the global level.
+func SetPackageLevel(prefix string, l Level) {
+	overridesMu.Lock()
+	m := PackageLevels()
+	m[prefix] = l
+	updateOverrides(m)
+	overridesMu.Unlock()
+}
+
+func RemovePackageLevel(prefix string) {
+	overridesMu.Lock()
+	m := PackageLevels()
+	delete(m, prefix)
+	updateOverrides(m)
+	overridesMu.Unlock()
+}
+
+// SetPackageLevels replaces all overrides at once
+func SetPackageLevels(m map[string]Level) {
+	overridesMu.Lock()
+	updateOverrides(m)
+	overridesMu.Unlock()
+}
+
+func PackageLevels() map[string]Level {
+	m := map[string]Level{}
+	for _, o := range getOverrides() {
+		m[o.prefix] = o.level
+	}
+	return m
+}
+
+// sourceLevel returns the level that applies to the caller of r and whether it comes from an override
+func sourceLevel(r *Record) (Level, bool) {
+	list := getOverrides()
+	if len(list) == 0 {
+		return getLevel(), false
+	}
+	name := r.Pkg
+	if r.Func != "" {
+		name += "." + r.Func
+	}
+	for _, o := range list {
+		if strings.HasPrefix(name, o.prefix) {
+			return o.level, true
+		}
+	}
+	return getLevel(), false
+}
@@ -2,6 +2,7 @@ package log
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/easygf/core/log/atexit"
@@ -11,12 +12,14 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Level int8
 
 const defaultMaxFileSize int64 = 4 * 1024 * 1024 * 1024
+const defaultMaxSegments = 100
 const (
 	maxLogFileSizeFile = "/etc/brick/max_log_size"
 )
@@ -48,8 +51,6 @@ var EnableLogCtx = true
 var logCtx = map[int64]string{}
 var logCtxMu sync.RWMutex
 
-var lasTime time.Time
-
 func init() {
 	red = fmt.Sprintf("\x1b[%dm", colorRed)
 	green = fmt.Sprintf("\x1b[%dm", colorGreen)
@@ -58,15 +59,13 @@ func init() {
 	blue = fmt.Sprintf("\u001B[%d;1m", 36)
 	purple = fmt.Sprintf("\x1b[%dm", colorPurple)
 	colorEnd = "\x1b[0m"
-	atexit.Register(func() {
-		if logger != nil {
-			logger.Flush()
-		}
-		if loggerImportant != nil {
-			loggerImportant.Flush()
-		}
-	})
+	atexit.RegisterHook("log", atexit.PriorityLog, 5*time.Second, FlushCtx)
 }
+
+// SetLogCtx binds a request ctx to goroutine i, 0 being the current one.
+//
+// Deprecated: entries leak unless cleared and are lost when work moves to
+// another goroutine, pass a context built by WithRequestID to the *Ctx functions.
 func SetLogCtx(c string, i int64) {
 	if i == 0 {
 		i = goid.Get()
@@ -90,18 +89,33 @@ func GetLogCtx(i int64) string {
 	return v
 }
 func Red(s string) string {
+	if !stdoutColor {
+		return s
+	}
 	return red + s + colorEnd
 }
 func Green(s string) string {
+	if !stdoutColor {
+		return s
+	}
 	return green + s + colorEnd
 }
 func Yellow(s string) string {
+	if !stdoutColor {
+		return s
+	}
 	return yellow + s + colorEnd
 }
 func Blue(s string) string {
+	if !stdoutColor {
+		return s
+	}
 	return blue + s + colorEnd
 }
 func Purple(s string) string {
+	if !stdoutColor {
+		return s
+	}
 	return purple + s + colorEnd
 }
 func (l Level) String() string {
@@ -160,12 +174,21 @@ func (l Level) Color() string {
 	}
 }
 
-var level = DebugLevel
+// accessed atomically, see getLevel
+var level = int32(DebugLevel)
 var fileSep string
 var modName = "UNKNOWN"
 var logger ILogger
 var DefaultLogDir string
-var loggerImportant ILogger
+
+// loggerImportant holds the ILogger of the important channel, stored once
+// under importantMu and read without it
+var loggerImportant atomic.Value
+
+func importantLogger() ILogger {
+	l, _ := loggerImportant.Load().(ILogger)
+	return l
+}
 
 func init() {
 	if runtime.GOOS == "windows" {
@@ -181,7 +204,23 @@ func GetModName() string {
 	return modName
 }
 func SetLogLevel(l Level) {
-	level = l
+	atomic.StoreInt32(&level, int32(l))
+}
+func GetLogLevel() Level {
+	return getLevel()
+}
+func getLevel() Level {
+	return Level(atomic.LoadInt32(&level))
+}
+
+// ParseLevel accepts the names returned by Level.String
+func ParseLevel(s string) (Level, error) {
+	for l := DebugLevel; l <= ImportantLevel; l++ {
+		if strings.EqualFold(s, l.String()) {
+			return l, nil
+		}
+	}
+	return InfoLevel, fmt.Errorf("unknown level %q", s)
 }
 func SetLog2Stdout(v bool) {
 	log2Stdout = v
@@ -194,81 +233,83 @@ func removeSuffixIfMatched(s string, suffix string) string {
 }
 
 var pid = 0
-var formatTimeSec uint32
-var formatTimeSecStr string
 
-func formatTime(t time.Time) string {
-	sec := uint32(t.Unix())
-	pre := formatTimeSec
-	preStr := formatTimeSecStr
-	if pre == sec {
-		// 受并行优化的影响，小概率取了旧值，因为是打LOG，就不搞这么严谨了
-		return preStr
+func newRecord(ctx context.Context, opt *Optimization, l Level, fields []Field, buf string, callerSkip int) *Record {
+	r := &Record{
+		Time:   time.Now(),
+		Level:  l,
+		Module: modName,
+		Pid:    pid,
+		Gid:    goid.Get(),
+		Msg:    buf,
+		Fields: fields,
 	}
-	x := t.Format("01-02T15:04:05")
-	formatTimeSec = sec
-	formatTimeSecStr = x
-	return x
-}
-
-func formatLog(opt *Optimization, l Level, buf string, callerSkip int) string {
-	now := time.Now()
+	r.fillCtx(ctx)
 
-	var b bytes.Buffer
+	if opt == nil || opt.CallerLine == 0 {
+		c := lookupCaller(callerSkip)
+		r.Pkg, r.Func, r.File, r.Line = c.pkg, c.fn, c.file, c.line
+	} else {
+		r.Pkg, r.Func = getPackageName(opt.CallerName)
+		r.File = path.Base(opt.ShortFile)
+		r.Line = opt.CallerLine
+	}
+	return r
+}
 
+// formatLog writes the text layout of r:
+// mod(pid,gid) time <ctx> level pkg/file:line:func msg k=v...
+func formatLog(b *bytes.Buffer, r *Record, color bool) {
 	// mod
-	b.WriteString(modName)
-
-	routineId := goid.Get()
+	b.WriteString(r.Module)
 
 	// 进程、协程
-	b.WriteString(fmt.Sprintf("(%d,%d) ", pid, routineId))
+	b.WriteByte('(')
+	writeInt(b, int64(r.Pid))
+	b.WriteByte(',')
+	writeInt(b, r.Gid)
+	b.WriteString(") ")
 	// 时间
-	b.WriteString(formatTime(now))
-	b.WriteString(fmt.Sprintf(".%04d ", now.Nanosecond()/100000))
+	b.WriteString(formatTime(r.Time))
+	b.WriteByte('.')
+	writeFixed(b, r.Time.Nanosecond()/100000, 4)
+	b.WriteByte(' ')
 
-	reqId := GetLogCtx(routineId)
-	if reqId != "" {
+	if r.Ctx != "" {
 		b.WriteString("<")
-		b.WriteString(reqId)
+		b.WriteString(r.Ctx)
 		b.WriteString("> ")
 	}
 
 	// 日志级别
-	b.WriteString(l.Color())
-	b.WriteString(l.ShortString())
-
-	var callerName, callerFile string
-	var callerLine int
-	if opt == nil || opt.CallerLine == 0 {
-		var ok bool
-		var pc uintptr
-		pc, callerFile, callerLine, ok = runtime.Caller(callerSkip)
-		callerName = ""
-		if ok {
-			callerName = runtime.FuncForPC(pc).Name()
-		}
-
-	} else {
-		callerFile = opt.ShortFile
-		callerName = opt.CallerName
-		callerLine = opt.CallerLine
+	if color {
+		b.WriteString(r.Level.Color())
 	}
+	b.WriteString(r.Level.ShortString())
 
 	// 调用位置
-	filePath, fileFunc := getPackageName(callerName)
-	b.WriteString(path.Join(filePath, path.Base(callerFile)))
-	b.WriteString(":")
-	b.WriteString(fmt.Sprintf("%d:", callerLine))
-	b.WriteString(fileFunc)
-	b.WriteString(colorEnd)
+	if r.Pkg != "" {
+		b.WriteString(r.Pkg)
+		b.WriteByte('/')
+	}
+	b.WriteString(r.File)
+	b.WriteByte(':')
+	writeInt(b, int64(r.Line))
+	b.WriteByte(':')
+	b.WriteString(r.Func)
+	if color {
+		b.WriteString(colorEnd)
+	}
 	b.WriteString(" ")
 
 	// 文本内容
-	b.WriteString(buf)
+	b.WriteString(r.Msg)
+	appendFields(b, r.Fields)
+	if r.TraceID != "" {
+		writeLogfmtPair(b, "trace_id", r.TraceID)
+		writeLogfmtPair(b, "span_id", r.SpanID)
+	}
 	b.WriteString("\n")
-
-	return b.String()
 }
 
 func getPackageName(f string) (string, string) {
@@ -296,17 +337,29 @@ type ILogger interface {
 	Flush()
 }
 
+// RecordWriter is implemented by loggers that encode records themselves,
+// other loggers receive the default text layout
+type RecordWriter interface {
+	WriteRecord(r *Record) error
+}
+
+func writeRecord(w ILogger, r *Record) error {
+	if rw, ok := w.(RecordWriter); ok {
+		return rw.WriteRecord(r)
+	}
+	b := getBuffer()
+	defer putBuffer(b)
+	textEncoder(outputColor(w)).Encode(b, r)
+	return w.Write(b.String())
+}
+
 func initLogImportant() error {
-	if loggerImportant != nil {
+	importantMu.Lock()
+	defer importantMu.Unlock()
+	if importantLogger() != nil {
 		return nil
 	}
-	l := &FileLogger{}
-	err := l.Init("/home/brick/log", "")
-	if err != nil {
-		return err
-	}
-	loggerImportant = l
-	return nil
+	return initImportantLocked(ImportantOptions{})
 }
 
 func PrintStack(skip int) {
@@ -323,29 +376,81 @@ func PrintStack(skip int) {
 	}
 }
 
-func logIt(opt *Optimization, l Level, msg string) {
-	if l < level {
+func logIt(lg *Logger, ctx context.Context, opt *Optimization, l Level, fields []Field, msg string) {
+	if !lg.Enabled(l) {
 		return
 	}
-	msg = formatLog(opt, l, msg, 4)
-	if logger != nil {
-		_ = logger.Write(msg)
-	} else {
-		fmt.Print(msg)
+	r := newRecord(ctx, opt, l, lg.recordFields(fields), msg, 4)
+	if c := lg.conf; c != nil {
+		if c.module != "" {
+			r.Module = c.module
+		}
+		// 独立配置的 logger 不受包级别覆盖影响
+		if c.out != nil || c.hasLevel() {
+			if sampled(lg, r) {
+				c.output(r)
+			}
+			return
+		}
 	}
+	srcLevel, overridden := sourceLevel(r)
+	if overridden && l < srcLevel {
+		return
+	}
+	if !sampled(lg, r) {
+		return
+	}
+	outputRecord(r, l >= srcLevel)
+}
+
+// outputRecord writes r to the main logger when main is set, and to the sinks
+func outputRecord(r *Record, main bool) {
+	var err error
+	if main {
+		if logger != nil {
+			err = writeRecord(logger, r)
+		} else if len(getSinks()) == 0 {
+			writeStdout(r)
+		}
+	}
+	dispatchSinks(r)
+	countLine(r.Level, err)
+}
+
+func writeStdout(r *Record) {
+	b := getBuffer()
+	textEncoder(stdoutColor).Encode(b, r)
+	_, _ = os.Stdout.Write(b.Bytes())
+	putBuffer(b)
 }
 
 func logItImportant(msg string) {
-	msg = formatLog(nil, ImportantLevel, msg, 4)
-	_ = loggerImportant.Write(msg)
+	_ = writeRecord(importantLogger(), newRecord(nil, nil, ImportantLevel, nil, msg, 4))
+}
+
+// AbortHook replaces what follows a Fatal, Panic or DPanic line, the stack
+// dump and the exit or panic, see log/logtest
+type AbortHook func(l Level, msg string)
+
+var abortHook atomic.Value // AbortHook
+
+// SetAbortHook installs h and returns the previous hook, nil restores the default
+func SetAbortHook(h AbortHook) AbortHook {
+	prev, _ := abortHook.Swap(h).(AbortHook)
+	return prev
 }
 
-func afterLog(l Level) {
-	if l == FatalLevel || l == PanicLevel || l == DPanicLevel {
-		PrintStack(4)
+func afterLog(l Level, msg string) {
+	if l != FatalLevel && l != PanicLevel && l != DPanicLevel {
+		return
 	}
+	if h, _ := abortHook.Load().(AbortHook); h != nil {
+		h(l, msg)
+		return
+	}
+	PrintStack(4)
 	if l == FatalLevel {
-		os.Exit(1)
+		atexit.Exit(1)
 	}
 	if l == PanicLevel {
 		panic("")
@@ -358,24 +463,24 @@ type Optimization struct {
 	CallerLine int
 }
 
-func logItFmt(opt *Optimization, l Level, template string, args ...interface{}) {
+func logItFmt(lg *Logger, ctx context.Context, opt *Optimization, l Level, fields []Field, template string, args ...interface{}) {
 	msg := template
 	if msg == "" && len(args) > 0 {
 		msg = fmt.Sprint(args...)
 	} else if msg != "" && len(args) > 0 {
 		msg = fmt.Sprintf(template, args...)
 	}
-	logIt(opt, l, msg)
-	afterLog(l)
+	logIt(lg, ctx, opt, l, fields, msg)
+	afterLog(l, msg)
 }
 
 func logItFmtImportant(template string, args ...interface{}) {
-	if loggerImportant == nil {
+	if importantLogger() == nil {
 		err := initLogImportant()
 		if err != nil {
 			return
 		}
-		if loggerImportant == nil {
+		if importantLogger() == nil {
 			panic("Unreachable")
 		}
 	}
@@ -388,111 +493,159 @@ func logItFmtImportant(template string, args ...interface{}) {
 	logItImportant(msg)
 }
 
-func logItArgs(l Level, args ...interface{}) {
+func logItArgs(lg *Logger, ctx context.Context, l Level, fields []Field, args ...interface{}) {
 	msg := fmt.Sprint(args...)
-	logIt(nil, l, msg)
-	afterLog(l)
+	logIt(lg, ctx, nil, l, fields, msg)
+	afterLog(l, msg)
 }
 
-func logItArgsWithOpt(opt *Optimization, l Level, args ...interface{}) {
+func logItArgsWithOpt(lg *Logger, ctx context.Context, opt *Optimization, l Level, fields []Field, args ...interface{}) {
 	msg := fmt.Sprint(args...)
-	logIt(opt, l, msg)
-	afterLog(l)
+	logIt(lg, ctx, opt, l, fields, msg)
+	afterLog(l, msg)
 }
 
-func ByCodef(code int, template string, args ...interface{}) {
-	prefix := fmt.Sprintf("errcode %d ", code)
+func codeLevel(code int) Level {
 	if code == 0 {
-		logItFmt(nil, InfoLevel, prefix+template, args...)
+		return InfoLevel
 	} else if code > 0 {
-		logItFmt(nil, WarnLevel, prefix+template, args...)
-	} else {
-		logItFmt(nil, ErrorLevel, prefix+template, args...)
+		return WarnLevel
 	}
+	return ErrorLevel
+}
+
+func ByCodef(code int, template string, args ...interface{}) {
+	prefix := fmt.Sprintf("errcode %d ", code)
+	logItFmt(Default(), nil, nil, codeLevel(code), nil, prefix+template, args...)
 }
 func Important(template string, args ...interface{}) {
-	logItFmt(nil, ImportantLevel, template, args...)
+	logItFmt(Default(), nil, nil, ImportantLevel, nil, template, args...)
 	logItFmtImportant(template, args...)
 }
 func Infof(template string, args ...interface{}) {
-	logItFmt(nil, InfoLevel, template, args...)
+	logItFmt(Default(), nil, nil, InfoLevel, nil, template, args...)
 }
 func InfofWithOpt(opt *Optimization, template string, args ...interface{}) {
-	logItFmt(opt, InfoLevel, template, args...)
+	logItFmt(Default(), nil, opt, InfoLevel, nil, template, args...)
 }
 func Printf(template string, args ...interface{}) {
-	logItFmt(nil, InfoLevel, template, args...)
+	logItFmt(Default(), nil, nil, InfoLevel, nil, template, args...)
 }
 func Fatal(args ...interface{}) {
-	logItArgs(FatalLevel, args...)
+	logItArgs(Default(), nil, FatalLevel, nil, args...)
 }
 func Panic(args ...interface{}) {
-	logItArgs(PanicLevel, args...)
+	logItArgs(Default(), nil, PanicLevel, nil, args...)
 }
 func DPanic(args ...interface{}) {
-	logItArgs(DPanicLevel, args...)
+	logItArgs(Default(), nil, DPanicLevel, nil, args...)
 }
 func Error(args ...interface{}) {
-	logItArgs(ErrorLevel, args...)
+	logItArgs(Default(), nil, ErrorLevel, nil, args...)
 }
 func ByCode(code int, args ...interface{}) {
 	prefix := fmt.Sprintf("errcode %d ", code)
 	args = append([]interface{}{prefix}, args...)
-	if code == 0 {
-		logItArgs(InfoLevel, args...)
-	} else if code > 0 {
-		logItArgs(WarnLevel, args...)
-	} else {
-		logItArgs(ErrorLevel, args...)
-	}
+	logItArgs(Default(), nil, codeLevel(code), nil, args...)
 }
 func Warn(args ...interface{}) {
-	logItArgs(WarnLevel, args...)
+	logItArgs(Default(), nil, WarnLevel, nil, args...)
 }
 func Info(args ...interface{}) {
-	logItArgs(InfoLevel, args...)
+	logItArgs(Default(), nil, InfoLevel, nil, args...)
 }
 func InfoWithOpt(opt *Optimization, args ...interface{}) {
-	logItArgsWithOpt(opt, InfoLevel, args...)
+	logItArgsWithOpt(Default(), nil, opt, InfoLevel, nil, args...)
 }
 func Debug(args ...interface{}) {
 	// fast check
-	if DebugLevel < level {
+	if !Default().Enabled(DebugLevel) {
 		return
 	}
-	logItArgs(DebugLevel, args...)
+	logItArgs(Default(), nil, DebugLevel, nil, args...)
 }
 func Debugf(template string, args ...interface{}) {
 	// fast check
-	if DebugLevel < level {
+	if !Default().Enabled(DebugLevel) {
 		return
 	}
-	logItFmt(nil, DebugLevel, template, args...)
+	logItFmt(Default(), nil, nil, DebugLevel, nil, template, args...)
 }
 func Warnf(template string, args ...interface{}) {
-	logItFmt(nil, WarnLevel, template, args...)
+	logItFmt(Default(), nil, nil, WarnLevel, nil, template, args...)
 }
 func WarnfWithOpt(opt *Optimization, template string, args ...interface{}) {
-	logItFmt(opt, WarnLevel, template, args...)
+	logItFmt(Default(), nil, opt, WarnLevel, nil, template, args...)
 }
 func Errorf(template string, args ...interface{}) {
-	logItFmt(nil, ErrorLevel, template, args...)
+	logItFmt(Default(), nil, nil, ErrorLevel, nil, template, args...)
 }
 func ErrorfWithOpt(opt *Optimization, template string, args ...interface{}) {
-	logItFmt(opt, ErrorLevel, template, args...)
+	logItFmt(Default(), nil, opt, ErrorLevel, nil, template, args...)
 }
 func DPanicf(template string, args ...interface{}) {
-	logItFmt(nil, DPanicLevel, template, args...)
+	logItFmt(Default(), nil, nil, DPanicLevel, nil, template, args...)
 }
 func Panicf(template string, args ...interface{}) {
-	logItFmt(nil, PanicLevel, template, args...)
+	logItFmt(Default(), nil, nil, PanicLevel, nil, template, args...)
 }
 func Fatalf(template string, args ...interface{}) {
-	logItFmt(nil, FatalLevel, template, args...)
+	logItFmt(Default(), nil, nil, FatalLevel, nil, template, args...)
 }
 func Sync() error {
+	return Default().Sync()
+}
+
+func syncPackage() error {
 	if logger != nil {
 		return logger.Sync()
 	}
 	return errors.New("logger not open")
 }
+
+type ctxFlusher interface {
+	FlushCtx(ctx context.Context) error
+}
+
+func flushLogger(ctx context.Context, w ILogger) error {
+	if f, ok := w.(ctxFlusher); ok {
+		return f.FlushCtx(ctx)
+	}
+	w.Flush()
+	return nil
+}
+
+// FlushCtx writes out the lines buffered by the main and important loggers and the sinks
+func FlushCtx(ctx context.Context) error {
+	var err error
+	for _, w := range []ILogger{logger, importantLogger()} {
+		if w == nil {
+			continue
+		}
+		if e := flushLogger(ctx, w); e != nil && err == nil {
+			err = e
+		}
+	}
+	flushSinks()
+	return err
+}
+
+// Close flushes the sinks and closes the main and important loggers, lines
+// logged afterwards are lost
+func Close() error {
+	flushSinks()
+	var err error
+	for _, w := range []ILogger{logger, importantLogger()} {
+		c, ok := w.(interface{ Close() error })
+		if !ok {
+			if w != nil {
+				w.Flush()
+			}
+			continue
+		}
+		if e := c.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -0,0 +1,266 @@
+// Package logconf drives the log package from a config key so levels and
+// sinks can be changed without a redeploy, e.g. config key log_<modName>:
+//
+//	{
+//	  "level": "info",
+//	  "packages": {"github.com/easygf/core/config": "debug"},
+//	  "sinks": [
+//	    {"name": "err", "type": "file", "level": "error", "dir": "/home/brick/log", "file": "err", "encoder": "json"},
+//	    {"name": "console", "type": "stderr", "level": "warn"}
+//	  ],
+//	  "sampling": {"error": {"first": 100, "thereafter": 100, "interval_ms": 1000}}
+//	}
+package logconf
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/easygf/core/config"
+	"github.com/easygf/core/log"
+)
+
+const sinkNamePrefix = "logconf:"
+
+type SinkConf struct {
+	Name string `json:"name"`
+	// file, stdout, stderr, syslog, udp or tcp
+	Type  string `json:"type"`
+	Level string `json:"level"`
+	// text, color_text, json or logfmt, by default text coloured for terminals
+	Encoder string `json:"encoder"`
+	// file
+	Dir  string `json:"dir"`
+	File string `json:"file"`
+	// udp, tcp and remote syslog
+	Addr string `json:"addr"`
+	// syslog
+	Tag string `json:"tag"`
+}
+
+// SamplingConf is log.SamplingPolicy with the interval in milliseconds
+type SamplingConf struct {
+	First      int   `json:"first"`
+	Thereafter int   `json:"thereafter"`
+	IntervalMs int64 `json:"interval_ms"`
+}
+
+type Conf struct {
+	Level    string            `json:"level"`
+	Packages map[string]string `json:"packages"`
+	Sinks    []SinkConf        `json:"sinks"`
+	// by level name
+	Sampling map[string]SamplingConf `json:"sampling"`
+}
+
+type defaults struct {
+	level    log.Level
+	packages map[string]log.Level
+	sampling map[log.Level]log.SamplingPolicy
+}
+
+var mu sync.Mutex
+var bound *config.JsonConfig
+var saved defaults
+var sinkNames []string
+
+// Bind is BindKey of the config key log_<modName>
+func Bind() error {
+	return BindKey("log_" + log.GetModName())
+}
+
+// BindKey applies key as soon as its local copy shows up and whenever it
+// changes, the settings in effect when it is called are restored once the key
+// is deleted
+func BindKey(key string) error {
+	mu.Lock()
+	if bound != nil {
+		mu.Unlock()
+		return errors.New("log config already bound")
+	}
+	saved = defaults{
+		level:    log.GetLogLevel(),
+		packages: log.PackageLevels(),
+		sampling: log.GetSampling(),
+	}
+	c := config.NewJsonConfig(key, &Conf{})
+	// taken before InitV2 so a concurrent BindKey fails, released if it fails
+	bound = c
+	mu.Unlock()
+	// the watcher reports the current value as ItemCreate, that is the first apply
+	err := c.InitV2(func(ev int, oldVal interface{}, newVal interface{}) {
+		if ev == config.ItemDelete {
+			revert()
+			return
+		}
+		if conf, ok := newVal.(*Conf); ok {
+			apply(conf)
+		}
+	})
+	if err != nil {
+		log.Errorf("err:%v", err)
+		mu.Lock()
+		bound = nil
+		mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func revert() {
+	mu.Lock()
+	defer mu.Unlock()
+	log.SetLogLevel(saved.level)
+	log.SetPackageLevels(saved.packages)
+	setSampling(saved.sampling)
+	removeSinks()
+	log.Infof("log config deleted, defaults restored")
+}
+
+// removeSinks also closes the outputs made by newSink, RemoveSink only flushes them
+func removeSinks() {
+	for _, name := range sinkNames {
+		if s := log.RemoveSink(name); s != nil {
+			closeOutput(s.Out)
+		}
+	}
+	sinkNames = nil
+}
+
+func closeOutput(out log.ILogger) {
+	if c, ok := out.(interface{ Close() error }); ok {
+		if err := c.Close(); err != nil {
+			log.Errorf("err:%v", err)
+		}
+	}
+}
+
+func apply(conf *Conf) {
+	mu.Lock()
+	defer mu.Unlock()
+	lv := saved.level
+	if conf.Level != "" {
+		l, err := log.ParseLevel(conf.Level)
+		if err != nil {
+			log.Errorf("err:%v", err)
+		} else {
+			lv = l
+		}
+	}
+	log.SetLogLevel(lv)
+
+	packages := map[string]log.Level{}
+	for k, v := range saved.packages {
+		packages[k] = v
+	}
+	for prefix, s := range conf.Packages {
+		l, err := log.ParseLevel(s)
+		if err != nil {
+			log.Errorf("package %s err:%v", prefix, err)
+			continue
+		}
+		packages[prefix] = l
+	}
+	log.SetPackageLevels(packages)
+
+	sampling := map[log.Level]log.SamplingPolicy{}
+	for k, v := range saved.sampling {
+		sampling[k] = v
+	}
+	for name, sc := range conf.Sampling {
+		l, err := log.ParseLevel(name)
+		if err != nil {
+			log.Errorf("sampling %s err:%v", name, err)
+			continue
+		}
+		sampling[l] = log.SamplingPolicy{
+			First:      sc.First,
+			Thereafter: sc.Thereafter,
+			Interval:   time.Duration(sc.IntervalMs) * time.Millisecond,
+		}
+	}
+	setSampling(sampling)
+
+	removeSinks()
+	for _, sc := range conf.Sinks {
+		s, err := newSink(&sc)
+		if err != nil {
+			log.Errorf("sink %s err:%v", sc.Name, err)
+			continue
+		}
+		err = log.AddSink(s)
+		if err != nil {
+			log.Errorf("sink %s err:%v", sc.Name, err)
+			closeOutput(s.Out)
+			continue
+		}
+		sinkNames = append(sinkNames, s.Name)
+	}
+	log.Infof("log config applied, level %s, %d package levels, %d sinks", lv, len(packages), len(sinkNames))
+}
+
+func setSampling(m map[log.Level]log.SamplingPolicy) {
+	for l := log.DebugLevel; l < log.DPanicLevel; l++ {
+		if p, ok := m[l]; ok {
+			log.SetSampling(l, &p)
+		} else {
+			log.SetSampling(l, nil)
+		}
+	}
+}
+
+func newSink(sc *SinkConf) (*log.Sink, error) {
+	if sc.Name == "" {
+		return nil, errors.New("sink name empty")
+	}
+	s := &log.Sink{
+		Name:  sinkNamePrefix + sc.Name,
+		Level: log.DebugLevel,
+	}
+	if sc.Level != "" {
+		l, err := log.ParseLevel(sc.Level)
+		if err != nil {
+			return nil, err
+		}
+		s.Level = l
+	}
+	if sc.Encoder != "" {
+		s.Encoder = log.EncoderByName(sc.Encoder)
+		if s.Encoder == nil {
+			return nil, fmt.Errorf("unknown encoder %q", sc.Encoder)
+		}
+	}
+	switch sc.Type {
+	case "file":
+		l := &log.FileLogger{}
+		err := l.Init(sc.Dir, sc.File)
+		if err != nil {
+			return nil, err
+		}
+		s.Out = l
+	case "stdout":
+		s.Out = log.Stdout()
+	case "stderr":
+		s.Out = log.Stderr()
+	case "syslog":
+		network := ""
+		if sc.Addr != "" {
+			network = "udp"
+		}
+		l, err := log.NewSyslogLogger(network, sc.Addr, sc.Tag)
+		if err != nil {
+			return nil, err
+		}
+		s.Out = l
+	case "udp", "tcp":
+		if sc.Addr == "" {
+			return nil, errors.New("addr empty")
+		}
+		s.Out = log.NewNetLogger(sc.Type, sc.Addr)
+	default:
+		return nil, fmt.Errorf("unknown sink type %q", sc.Type)
+	}
+	return s, nil
+}
@@ -0,0 +1,309 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+)
+
+// Logger is a named logger with its own fields, level and output, so a
+// library can log apart from the service embedding it
+//
+//	db := log.New(log.WithName("db"), log.WithLevel(log.WarnLevel))
+//	db.With("table", "user").Warnf("slow query %v", cost)
+//
+// child loggers created by Named and With inherit the name and fields of their
+// parent and share its level and output. A Logger without its own level or
+// output follows the package settings, the package level functions log
+// through Default
+type Logger struct {
+	name   string
+	fields []Field
+	conf   *loggerConf
+}
+
+// loggerConf is shared by a logger and its children
+type loggerConf struct {
+	level  int32 // atomic, levelUnset follows the package level
+	out    ILogger
+	module string
+	stdout bool
+}
+
+const levelUnset = -128
+
+type Option func(*Logger)
+
+// WithName sets the name logged in the logger field
+func WithName(name string) Option {
+	return func(lg *Logger) {
+		lg.name = name
+	}
+}
+
+// WithLevel gives the logger its own level, the package level and the
+// package overrides of SetPackageLevel no longer apply
+func WithLevel(l Level) Option {
+	return func(lg *Logger) {
+		lg.conf.level = int32(l)
+	}
+}
+
+// WithOutput writes the lines of the logger to out instead of the package
+// logger and sinks, out is owned by the caller who flushes and closes it
+func WithOutput(out ILogger) Option {
+	return func(lg *Logger) {
+		lg.conf.out = out
+	}
+}
+
+// WithModule replaces the module name of SetModName in the lines
+func WithModule(mod string) Option {
+	return func(lg *Logger) {
+		lg.conf.module = mod
+	}
+}
+
+// WithStdout echoes the lines written to the output of WithOutput to stdout
+func WithStdout(v bool) Option {
+	return func(lg *Logger) {
+		lg.conf.stdout = v
+	}
+}
+
+func WithFields(kv ...interface{}) Option {
+	return func(lg *Logger) {
+		lg.fields = joinFields(lg.fields, toFields(kv))
+	}
+}
+
+func New(opts ...Option) *Logger {
+	lg := &Logger{conf: &loggerConf{level: levelUnset}}
+	for _, o := range opts {
+		o(lg)
+	}
+	return lg
+}
+
+var defaultLogger atomic.Value
+
+func init() {
+	defaultLogger.Store(&Logger{})
+}
+
+// Default returns the logger behind the package level functions
+func Default() *Logger {
+	return defaultLogger.Load().(*Logger)
+}
+
+// SetDefault replaces the logger behind the package level functions, nil
+// restores the one following the package settings
+func SetDefault(lg *Logger) {
+	if lg == nil {
+		lg = &Logger{}
+	}
+	defaultLogger.Store(lg)
+}
+
+// Named returns a child logger, names are joined with a dot
+func (lg *Logger) Named(name string) *Logger {
+	if lg.name != "" {
+		name = lg.name + "." + name
+	}
+	return &Logger{name: name, fields: lg.fields, conf: lg.conf}
+}
+
+func (lg *Logger) With(kv ...interface{}) *Logger {
+	return &Logger{name: lg.name, fields: joinFields(lg.fields, toFields(kv)), conf: lg.conf}
+}
+
+func (lg *Logger) Name() string {
+	return lg.name
+}
+
+func (lg *Logger) Fields() []Field {
+	return lg.fields
+}
+
+func (c *loggerConf) hasLevel() bool {
+	return atomic.LoadInt32(&c.level) != levelUnset
+}
+
+// SetLevel changes the level of lg and the loggers sharing its settings, for
+// a logger following the package settings it is SetLogLevel
+func (lg *Logger) SetLevel(l Level) {
+	if lg.conf == nil {
+		SetLogLevel(l)
+		return
+	}
+	atomic.StoreInt32(&lg.conf.level, int32(l))
+}
+
+func (lg *Logger) GetLevel() Level {
+	if lg.conf != nil {
+		if l := atomic.LoadInt32(&lg.conf.level); l != levelUnset {
+			return Level(l)
+		}
+	}
+	return getLevel()
+}
+
+// Enabled reports whether a line at l could be written by lg
+func (lg *Logger) Enabled(l Level) bool {
+	if c := lg.conf; c != nil {
+		if lv := atomic.LoadInt32(&c.level); lv != levelUnset {
+			return int32(l) >= lv
+		}
+		if c.out != nil {
+			return l >= getLevel()
+		}
+	}
+	return levelEnabled(l)
+}
+
+func (lg *Logger) recordFields(fields []Field) []Field {
+	fields = joinFields(lg.fields, fields)
+	if lg.name != "" {
+		fields = joinFields([]Field{F("logger", lg.name)}, fields)
+	}
+	return fields
+}
+
+// route returns the settings of lg when it has its own level or output,
+// nil when its lines go through the package settings
+func (lg *Logger) route() *loggerConf {
+	if c := lg.conf; c != nil && (c.out != nil || c.hasLevel()) {
+		return c
+	}
+	return nil
+}
+
+// output writes r for a logger with its own level or output
+func (c *loggerConf) output(r *Record) {
+	if c.out == nil {
+		outputRecord(r, true)
+		return
+	}
+	if c.stdout {
+		writeStdout(r)
+	}
+	countLine(r.Level, writeRecord(c.out, r))
+}
+
+func (lg *Logger) Sync() error {
+	if lg.conf != nil && lg.conf.out != nil {
+		return lg.conf.out.Sync()
+	}
+	return syncPackage()
+}
+
+func (lg *Logger) Debug(args ...interface{}) {
+	if !lg.Enabled(DebugLevel) {
+		return
+	}
+	logItArgs(lg, nil, DebugLevel, nil, args...)
+}
+func (lg *Logger) Debugf(template string, args ...interface{}) {
+	if !lg.Enabled(DebugLevel) {
+		return
+	}
+	logItFmt(lg, nil, nil, DebugLevel, nil, template, args...)
+}
+func (lg *Logger) Debugw(msg string, kv ...interface{}) {
+	if !lg.Enabled(DebugLevel) {
+		return
+	}
+	logItFmt(lg, nil, nil, DebugLevel, toFields(kv), msg)
+}
+func (lg *Logger) Info(args ...interface{}) {
+	logItArgs(lg, nil, InfoLevel, nil, args...)
+}
+func (lg *Logger) Infof(template string, args ...interface{}) {
+	logItFmt(lg, nil, nil, InfoLevel, nil, template, args...)
+}
+func (lg *Logger) Infow(msg string, kv ...interface{}) {
+	logItFmt(lg, nil, nil, InfoLevel, toFields(kv), msg)
+}
+func (lg *Logger) Printf(template string, args ...interface{}) {
+	logItFmt(lg, nil, nil, InfoLevel, nil, template, args...)
+}
+func (lg *Logger) Warn(args ...interface{}) {
+	logItArgs(lg, nil, WarnLevel, nil, args...)
+}
+func (lg *Logger) Warnf(template string, args ...interface{}) {
+	logItFmt(lg, nil, nil, WarnLevel, nil, template, args...)
+}
+func (lg *Logger) Warnw(msg string, kv ...interface{}) {
+	logItFmt(lg, nil, nil, WarnLevel, toFields(kv), msg)
+}
+func (lg *Logger) Error(args ...interface{}) {
+	logItArgs(lg, nil, ErrorLevel, nil, args...)
+}
+func (lg *Logger) Errorf(template string, args ...interface{}) {
+	logItFmt(lg, nil, nil, ErrorLevel, nil, template, args...)
+}
+func (lg *Logger) Errorw(msg string, kv ...interface{}) {
+	logItFmt(lg, nil, nil, ErrorLevel, toFields(kv), msg)
+}
+func (lg *Logger) DPanic(args ...interface{}) {
+	logItArgs(lg, nil, DPanicLevel, nil, args...)
+}
+func (lg *Logger) DPanicf(template string, args ...interface{}) {
+	logItFmt(lg, nil, nil, DPanicLevel, nil, template, args...)
+}
+func (lg *Logger) Panic(args ...interface{}) {
+	logItArgs(lg, nil, PanicLevel, nil, args...)
+}
+func (lg *Logger) Panicf(template string, args ...interface{}) {
+	logItFmt(lg, nil, nil, PanicLevel, nil, template, args...)
+}
+func (lg *Logger) Fatal(args ...interface{}) {
+	logItArgs(lg, nil, FatalLevel, nil, args...)
+}
+func (lg *Logger) Fatalf(template string, args ...interface{}) {
+	logItFmt(lg, nil, nil, FatalLevel, nil, template, args...)
+}
+func (lg *Logger) Important(template string, args ...interface{}) {
+	logItFmt(lg, nil, nil, ImportantLevel, nil, template, args...)
+	logItFmtImportant(template, args...)
+}
+func (lg *Logger) ByCode(code int, args ...interface{}) {
+	prefix := fmt.Sprintf("errcode %d ", code)
+	args = append([]interface{}{prefix}, args...)
+	logItArgs(lg, nil, codeLevel(code), nil, args...)
+}
+func (lg *Logger) ByCodef(code int, template string, args ...interface{}) {
+	prefix := fmt.Sprintf("errcode %d ", code)
+	logItFmt(lg, nil, nil, codeLevel(code), nil, prefix+template, args...)
+}
+
+func (lg *Logger) DebugCtx(ctx context.Context, args ...interface{}) {
+	if !lg.Enabled(DebugLevel) {
+		return
+	}
+	logItArgs(lg, ctx, DebugLevel, nil, args...)
+}
+func (lg *Logger) DebugfCtx(ctx context.Context, template string, args ...interface{}) {
+	if !lg.Enabled(DebugLevel) {
+		return
+	}
+	logItFmt(lg, ctx, nil, DebugLevel, nil, template, args...)
+}
+func (lg *Logger) InfoCtx(ctx context.Context, args ...interface{}) {
+	logItArgs(lg, ctx, InfoLevel, nil, args...)
+}
+func (lg *Logger) InfofCtx(ctx context.Context, template string, args ...interface{}) {
+	logItFmt(lg, ctx, nil, InfoLevel, nil, template, args...)
+}
+func (lg *Logger) WarnCtx(ctx context.Context, args ...interface{}) {
+	logItArgs(lg, ctx, WarnLevel, nil, args...)
+}
+func (lg *Logger) WarnfCtx(ctx context.Context, template string, args ...interface{}) {
+	logItFmt(lg, ctx, nil, WarnLevel, nil, template, args...)
+}
+func (lg *Logger) ErrorCtx(ctx context.Context, args ...interface{}) {
+	logItArgs(lg, ctx, ErrorLevel, nil, args...)
+}
+func (lg *Logger) ErrorfCtx(ctx context.Context, template string, args ...interface{}) {
+	logItFmt(lg, ctx, nil, ErrorLevel, nil, template, args...)
+}
@@ -0,0 +1,232 @@
+// Package logtest captures the lines logged during a test
+//
+//	func TestLogin(t *testing.T) {
+//		rec := logtest.New(t)
+//		login("bob")
+//		rec.AssertLogged(logtest.Level(log.WarnLevel), logtest.Field("user", "bob"))
+//	}
+//
+// New installs a sink receiving every line and replaces the exit of Fatal and
+// the panics of Panic and DPanic with recorded aborts, so the code after them
+// keeps running. Both are undone in t.Cleanup. The sink is global, tests
+// running in parallel see each other's lines.
+package logtest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/easygf/core/log"
+	"github.com/easygf/core/log/parse"
+)
+
+// Entry is a captured line
+type Entry struct {
+	Time  time.Time
+	Level log.Level
+	// name of the log.Logger, empty for the package functions
+	Logger string
+	Msg    string
+	// pkg/file.go:line
+	Caller string
+	Func   string
+	Ctx    string
+	Fields []log.Field
+}
+
+// Field returns the value of the last field named key
+func (e Entry) Field(key string) (interface{}, bool) {
+	for i := len(e.Fields) - 1; i >= 0; i-- {
+		if e.Fields[i].Key == key {
+			return e.Fields[i].Val, true
+		}
+	}
+	return nil, false
+}
+
+func (e Entry) String() string {
+	return fmt.Sprintf("%s %s %s %v", e.Level.ShortString(), e.Caller, e.Msg, e.Fields)
+}
+
+// Abort is a Fatal, Panic or DPanic which would have exited or panicked
+type Abort struct {
+	Level log.Level
+	Msg   string
+}
+
+type Recorder struct {
+	t    testing.TB
+	sink *log.Sink
+
+	mu      sync.Mutex
+	entries []Entry
+	aborts  []Abort
+}
+
+// New starts capturing until the end of t
+func New(t testing.TB) *Recorder {
+	t.Helper()
+	r := &Recorder{t: t}
+	r.sink = &log.Sink{Name: fmt.Sprintf("logtest:%p", r), Level: log.DebugLevel, Out: r, Records: true}
+	if err := log.AddSink(r.sink); err != nil {
+		t.Fatalf("logtest: %v", err)
+	}
+	prev := log.SetAbortHook(r.abort)
+	t.Cleanup(func() {
+		log.SetAbortHook(prev)
+		log.RemoveSink(r.sink.Name)
+	})
+	return r
+}
+
+// Logger returns a log.Logger writing straight to r, lines of loggers with
+// their own output never reach the sink
+func (r *Recorder) Logger(opts ...log.Option) *log.Logger {
+	return log.New(append(opts, log.WithOutput(r))...)
+}
+
+func (r *Recorder) abort(l log.Level, msg string) {
+	r.mu.Lock()
+	r.aborts = append(r.aborts, Abort{Level: l, Msg: msg})
+	r.mu.Unlock()
+}
+
+func newEntry(rec *log.Record) Entry {
+	e := Entry{
+		Time:   rec.Time,
+		Level:  rec.Level,
+		Msg:    rec.Msg,
+		Caller: rec.Caller(),
+		Func:   rec.Func,
+		Ctx:    rec.Ctx,
+		Fields: append([]log.Field(nil), rec.Fields...),
+	}
+	for _, f := range e.Fields {
+		if f.Key == "logger" {
+			e.Logger = fmt.Sprint(f.Val)
+			break
+		}
+	}
+	return e
+}
+
+func (r *Recorder) WriteRecord(rec *log.Record) error {
+	e := newEntry(rec)
+	r.mu.Lock()
+	r.entries = append(r.entries, e)
+	r.mu.Unlock()
+	return nil
+}
+
+// Write takes the text layout, for callers bypassing WriteRecord
+func (r *Recorder) Write(buf string) error {
+	rec, ok := parse.Line(strings.TrimSuffix(buf, "\n"), time.Now().Year())
+	if !ok {
+		rec = &log.Record{Time: time.Now(), Level: log.InfoLevel, Msg: buf}
+	}
+	return r.WriteRecord(rec)
+}
+
+func (r *Recorder) Sync() error {
+	return nil
+}
+
+func (r *Recorder) Flush() {}
+
+// Entries waits for the queued lines and returns everything captured so far
+// in time order
+func (r *Recorder) Entries() []Entry {
+	r.sink.Flush()
+	r.mu.Lock()
+	out := append([]Entry(nil), r.entries...)
+	r.mu.Unlock()
+	// the sink lines arrive later than those of Logger
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Time.Before(out[j].Time)
+	})
+	return out
+}
+
+// Find returns the entries matching all of m
+func (r *Recorder) Find(m ...Matcher) []Entry {
+	var out []Entry
+	for _, e := range r.Entries() {
+		if All(m...).match(e) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func (r *Recorder) Count(m ...Matcher) int {
+	return len(r.Find(m...))
+}
+
+func (r *Recorder) Has(m ...Matcher) bool {
+	return r.Count(m...) > 0
+}
+
+// Aborts returns the Fatal, Panic and DPanic calls caught so far
+func (r *Recorder) Aborts() []Abort {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Abort(nil), r.aborts...)
+}
+
+// Exited reports whether a Fatal would have ended the process
+func (r *Recorder) Exited() bool {
+	return r.aborted(log.FatalLevel)
+}
+
+// Panicked reports whether a Panic would have panicked
+func (r *Recorder) Panicked() bool {
+	return r.aborted(log.PanicLevel)
+}
+
+func (r *Recorder) aborted(l log.Level) bool {
+	for _, a := range r.Aborts() {
+		if a.Level == l {
+			return true
+		}
+	}
+	return false
+}
+
+// Reset drops the entries and aborts captured so far
+func (r *Recorder) Reset() {
+	r.sink.Flush()
+	r.mu.Lock()
+	r.entries = nil
+	r.aborts = nil
+	r.mu.Unlock()
+}
+
+// AssertLogged fails the test unless an entry matches all of m
+func (r *Recorder) AssertLogged(m ...Matcher) {
+	r.t.Helper()
+	if !r.Has(m...) {
+		r.t.Errorf("logtest: no line matching %s in\n%s", describe(m), r.dump())
+	}
+}
+
+// AssertNotLogged fails the test if an entry matches all of m
+func (r *Recorder) AssertNotLogged(m ...Matcher) {
+	r.t.Helper()
+	if found := r.Find(m...); len(found) > 0 {
+		r.t.Errorf("logtest: unexpected line matching %s: %s", describe(m), found[0])
+	}
+}
+
+func (r *Recorder) dump() string {
+	var b strings.Builder
+	for _, e := range r.Entries() {
+		b.WriteString("\t")
+		b.WriteString(e.String())
+		b.WriteString("\n")
+	}
+	return b.String()
+}
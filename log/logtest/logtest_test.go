@@ -0,0 +1,104 @@
+package logtest
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/easygf/core/log"
+)
+
+// above returns file.go:line of the line before its call
+func above() string {
+	_, file, line, _ := runtime.Caller(1)
+	return fmt.Sprintf("%s:%d", file[strings.LastIndexByte(file, '/')+1:], line-1)
+}
+
+func TestCapture(t *testing.T) {
+	rec := New(t)
+	log.Debugw("debug line", "uid", 42)
+	debugAt := above()
+	log.Warnf("warn %s", "line")
+	warnAt := above()
+
+	rec.AssertLogged(Level(log.DebugLevel), Msg("debug line"), Field("uid", 42), Caller(debugAt))
+	rec.AssertLogged(Level(log.WarnLevel), Msg("warn line"), Caller(warnAt))
+	rec.AssertNotLogged(Level(log.ErrorLevel))
+	if n := rec.Count(MinLevel(log.DebugLevel), Caller("logtest_test.go")); n != 2 {
+		t.Errorf("got %d entries, want 2", n)
+	}
+}
+
+func TestCaptureLogger(t *testing.T) {
+	rec := New(t)
+	lg := log.New(log.WithName("db"), log.WithFields("shard", 3))
+	lg.Infow("query", "rows", 7)
+	at := above()
+
+	rec.AssertLogged(Logger("db"), Msg("query"), Field("shard", 3), Field("rows", 7), Caller(at))
+
+	rec.Logger(log.WithName("own")).Infof("direct")
+	rec.AssertLogged(Logger("own"), Msg("direct"))
+}
+
+func TestAborts(t *testing.T) {
+	rec := New(t)
+	log.Fatalf("fatal %d", 1)
+	log.Panic("panic")
+	log.DPanic("dpanic")
+	// still running
+	log.Infof("after")
+
+	want := []Abort{{log.FatalLevel, "fatal 1"}, {log.PanicLevel, "panic"}, {log.DPanicLevel, "dpanic"}}
+	got := rec.Aborts()
+	if len(got) != len(want) {
+		t.Fatalf("got aborts %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("abort %d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+	if !rec.Exited() || !rec.Panicked() {
+		t.Errorf("exited %v panicked %v", rec.Exited(), rec.Panicked())
+	}
+	rec.AssertLogged(Level(log.FatalLevel), Msg("fatal 1"))
+	rec.AssertLogged(Msg("after"))
+
+	rec.Reset()
+	if len(rec.Aborts()) != 0 || len(rec.Entries()) != 0 {
+		t.Error("reset kept entries")
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	var outer []Abort
+	prev := log.SetAbortHook(func(l log.Level, msg string) {
+		outer = append(outer, Abort{l, msg})
+	})
+	defer log.SetAbortHook(prev)
+
+	var name string
+	t.Run("inner", func(t *testing.T) {
+		rec := New(t)
+		name = rec.sink.Name
+		log.Panic("inner")
+		if len(rec.Aborts()) != 1 {
+			t.Errorf("got aborts %v", rec.Aborts())
+		}
+	})
+	if len(outer) != 0 {
+		t.Fatalf("outer hook saw %v during the subtest", outer)
+	}
+
+	for _, s := range log.GetSinks() {
+		if s.Name == name {
+			t.Errorf("sink %s still registered", name)
+		}
+	}
+	log.Panic("outer")
+	if len(outer) != 1 || outer[0].Msg != "outer" {
+		t.Errorf("abort hook not restored, outer saw %v", outer)
+	}
+}
@@ -0,0 +1,117 @@
+package logtest
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/easygf/core/log"
+)
+
+// Matcher selects entries, desc shows up in the failures of the assertions
+type Matcher struct {
+	desc  string
+	match func(e Entry) bool
+}
+
+func NewMatcher(desc string, match func(e Entry) bool) Matcher {
+	return Matcher{desc: desc, match: match}
+}
+
+func (m Matcher) Match(e Entry) bool {
+	return m.match(e)
+}
+
+func (m Matcher) String() string {
+	return m.desc
+}
+
+func describe(m []Matcher) string {
+	if len(m) == 0 {
+		return "anything"
+	}
+	descs := make([]string, len(m))
+	for i, x := range m {
+		descs[i] = x.desc
+	}
+	return strings.Join(descs, " and ")
+}
+
+func All(m ...Matcher) Matcher {
+	return NewMatcher(describe(m), func(e Entry) bool {
+		for _, x := range m {
+			if !x.match(e) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func Not(m Matcher) Matcher {
+	return NewMatcher("not "+m.desc, func(e Entry) bool {
+		return !m.match(e)
+	})
+}
+
+func Level(l log.Level) Matcher {
+	return NewMatcher("level "+l.String(), func(e Entry) bool {
+		return e.Level == l
+	})
+}
+
+func MinLevel(l log.Level) Matcher {
+	return NewMatcher("level >= "+l.String(), func(e Entry) bool {
+		return e.Level >= l
+	})
+}
+
+func Msg(msg string) Matcher {
+	return NewMatcher(fmt.Sprintf("msg %q", msg), func(e Entry) bool {
+		return e.Msg == msg
+	})
+}
+
+func MsgContains(s string) Matcher {
+	return NewMatcher(fmt.Sprintf("msg containing %q", s), func(e Entry) bool {
+		return strings.Contains(e.Msg, s)
+	})
+}
+
+// MsgMatches panics on a bad expr, like regexp.MustCompile
+func MsgMatches(expr string) Matcher {
+	re := regexp.MustCompile(expr)
+	return NewMatcher(fmt.Sprintf("msg matching %q", expr), func(e Entry) bool {
+		return re.MatchString(e.Msg)
+	})
+}
+
+// Field matches a field named key holding val, values printing the same
+// also match so 42 finds an int64 field
+func Field(key string, val interface{}) Matcher {
+	return NewMatcher(fmt.Sprintf("field %s=%v", key, val), func(e Entry) bool {
+		v, ok := e.Field(key)
+		return ok && (reflect.DeepEqual(v, val) || fmt.Sprint(v) == fmt.Sprint(val))
+	})
+}
+
+func HasField(key string) Matcher {
+	return NewMatcher("field "+key, func(e Entry) bool {
+		_, ok := e.Field(key)
+		return ok
+	})
+}
+
+// Caller matches entries whose pkg/file.go:line contains s
+func Caller(s string) Matcher {
+	return NewMatcher(fmt.Sprintf("caller containing %q", s), func(e Entry) bool {
+		return strings.Contains(e.Caller, s)
+	})
+}
+
+func Logger(name string) Matcher {
+	return NewMatcher(fmt.Sprintf("logger %q", name), func(e Entry) bool {
+		return e.Logger == name
+	})
+}
@@ -0,0 +1,55 @@
+package parse
+
+import (
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// name2026101715.log, name2026101715.3.log and their .gz
+var fileRe = regexp.MustCompile(`^(.*?)(\d{10})(?:\.(\d+))?\.log(\.gz)?$`)
+
+// FileName is the name of a file written by log.FileLogger
+type FileName struct {
+	Path string
+	// logger name
+	Name string
+	// 2006010215
+	Hour string
+	// segment within the hour
+	Seq        int
+	Compressed bool
+}
+
+// ParseFileName parses the base name of path
+func ParseFileName(path string) (FileName, bool) {
+	m := fileRe.FindStringSubmatch(filepath.Base(path))
+	if m == nil {
+		return FileName{Path: path}, false
+	}
+	seq, _ := strconv.Atoi(m[3])
+	return FileName{Path: path, Name: m[1], Hour: m[2], Seq: seq, Compressed: strings.HasSuffix(path, ".gz")}, true
+}
+
+// Year returns the year of the lines in the file, the current year when unknown
+func (f FileName) Year() int {
+	if f.Hour != "" {
+		if y, err := strconv.Atoi(f.Hour[:4]); err == nil {
+			return y
+		}
+	}
+	return time.Now().Year()
+}
+
+// Less orders files by hour, then segment, then logger name
+func (f FileName) Less(o FileName) bool {
+	if f.Hour != o.Hour {
+		return f.Hour < o.Hour
+	}
+	if f.Seq != o.Seq {
+		return f.Seq < o.Seq
+	}
+	return f.Name < o.Name
+}
@@ -0,0 +1,220 @@
+// Package parse reads back the text layout of the log package,
+//
+//	mod(pid,gid) 01-02T15:04:05.0000 <ctx> LVL pkg/file.go:line:func msg k=v trace_id=.. span_id=..
+//
+// into records. The layout loses some detail: the year, time below 100µs, the
+// difference between DPanic and Panic, which both read as Panic, and where the
+// message ends and the fields begin, fields are left in Msg. The hashes of
+// audit files are dropped.
+package parse
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/easygf/core/log"
+)
+
+const timeLayout = "2006-01-02T15:04:05.0000"
+
+var colorRe = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+var lineRe = regexp.MustCompile(`^(\S*)\((\d+),(-?\d+)\) (\d\d-\d\dT\d\d:\d\d:\d\d\.\d{4}) (?:<([^>]*)> )?([A-Z]{3}|L\(-?\d+\)) (?:(\S*)/)?([^/\s]+):(\d+):(\S*) ?(.*)$`)
+
+var traceRe = regexp.MustCompile(` trace_id=([0-9a-f]{32}) span_id=([0-9a-f]{16})$`)
+
+// the hash chain suffix and the start and end markers of log.FileLogger.EnableAudit
+var auditRe = regexp.MustCompile(` #h=[0-9a-f]{64}$`)
+
+const (
+	auditSeal  = "#audit-seal"
+	auditStart = "#audit-start "
+)
+
+var shortLevels = map[string]log.Level{
+	"DBG": log.DebugLevel,
+	"INF": log.InfoLevel,
+	"WAR": log.WarnLevel,
+	"ERR": log.ErrorLevel,
+	"PAN": log.PanicLevel,
+	"FAT": log.FatalLevel,
+	"IMP": log.ImportantLevel,
+}
+
+// stripAudit removes the hash of an audit line
+func stripAudit(s string) string {
+	if !strings.Contains(s, " #h=") {
+		return s
+	}
+	return auditRe.ReplaceAllString(s, "")
+}
+
+// StripColor removes the ANSI colour codes of s
+func StripColor(s string) string {
+	if strings.IndexByte(s, 0x1b) < 0 {
+		return s
+	}
+	return colorRe.ReplaceAllString(s, "")
+}
+
+// ParseLevel accepts the level names of log.ParseLevel and the short forms of the layout, e.g. WAR
+func ParseLevel(s string) (log.Level, error) {
+	if l, ok := shortLevels[strings.ToUpper(s)]; ok {
+		return l, nil
+	}
+	return log.ParseLevel(s)
+}
+
+// Line parses the first line of a record, colours are stripped. ok is false
+// for lines not starting a record, e.g. the continuation of a multi-line message.
+func Line(s string, year int) (r *log.Record, ok bool) {
+	m := lineRe.FindStringSubmatch(StripColor(stripAudit(strings.TrimRight(s, "\r\n"))))
+	if m == nil {
+		return nil, false
+	}
+	t, err := time.ParseInLocation(timeLayout, strconv.Itoa(year)+"-"+m[4], time.Local)
+	if err != nil {
+		return nil, false
+	}
+	r = &log.Record{
+		Time:   t,
+		Module: m[1],
+		Ctx:    m[5],
+		Pkg:    m[7],
+		File:   m[8],
+		Func:   m[10],
+		Msg:    m[11],
+	}
+	r.Pid, _ = strconv.Atoi(m[2])
+	r.Gid, _ = strconv.ParseInt(m[3], 10, 64)
+	r.Line, _ = strconv.Atoi(m[9])
+	if l, ok := shortLevels[m[6]]; ok {
+		r.Level = l
+	} else {
+		n, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(m[6], "L("), ")"))
+		r.Level = log.Level(n)
+	}
+	splitTrace(r)
+	return r, true
+}
+
+// splitTrace moves the trace ids the layout appends to the message into r
+func splitTrace(r *log.Record) {
+	m := traceRe.FindStringSubmatchIndex(r.Msg)
+	if m == nil {
+		return
+	}
+	r.TraceID = r.Msg[m[2]:m[3]]
+	r.SpanID = r.Msg[m[4]:m[5]]
+	r.Msg = r.Msg[:m[0]]
+}
+
+// Scanner reads records, joining the lines of multi-line messages, lines
+// before the first record are skipped
+//
+//	s := parse.NewScanner(f, 2026)
+//	for s.Scan() {
+//		r := s.Record()
+//	}
+//	err := s.Err()
+type Scanner struct {
+	r    *bufio.Reader
+	year int
+
+	rec  *log.Record
+	raw  []string
+	next *log.Record
+	// raw line of next
+	nextRaw string
+	err     error
+}
+
+func NewScanner(r io.Reader, year int) *Scanner {
+	return &Scanner{r: bufio.NewReaderSize(r, 64*1024), year: year}
+}
+
+func (s *Scanner) readLine() (string, bool) {
+	if s.err != nil {
+		return "", false
+	}
+	line, err := s.r.ReadString('\n')
+	if err != nil {
+		if err != io.EOF {
+			s.err = err
+			return "", false
+		}
+		s.err = io.EOF
+		if line == "" {
+			return "", false
+		}
+	}
+	return strings.TrimRight(line, "\r\n"), true
+}
+
+// Scan advances to the next record, false at the end of the input or on error
+func (s *Scanner) Scan() bool {
+	s.rec, s.raw = nil, nil
+	for s.next == nil {
+		line, ok := s.readLine()
+		if !ok {
+			return false
+		}
+		s.next, _ = Line(line, s.year)
+		s.nextRaw = line
+	}
+	s.rec, s.raw = s.next, []string{s.nextRaw}
+	s.next = nil
+	var msg []string
+	for {
+		line, ok := s.readLine()
+		if !ok {
+			break
+		}
+		if r, ok := Line(line, s.year); ok {
+			s.next, s.nextRaw = r, line
+			break
+		}
+		s.raw = append(s.raw, line)
+		text := stripAudit(line)
+		if text == auditSeal || strings.HasPrefix(text, auditStart) {
+			continue
+		}
+		msg = append(msg, StripColor(text))
+	}
+	if len(msg) > 0 {
+		// the trace ids follow the last line of the message
+		s.rec.Msg = strings.Join(append([]string{s.rec.Msg + s.traceSuffix()}, msg...), "\n")
+		s.rec.TraceID, s.rec.SpanID = "", ""
+		splitTrace(s.rec)
+	}
+	return true
+}
+
+// traceSuffix undoes the splitTrace of a first line which turns out to continue
+func (s *Scanner) traceSuffix() string {
+	if s.rec.TraceID == "" {
+		return ""
+	}
+	return " trace_id=" + s.rec.TraceID + " span_id=" + s.rec.SpanID
+}
+
+// Record returns the record read by the last Scan
+func (s *Scanner) Record() *log.Record {
+	return s.rec
+}
+
+// Raw returns the lines of the record as read, colours included
+func (s *Scanner) Raw() []string {
+	return s.raw
+}
+
+func (s *Scanner) Err() error {
+	if s.err == io.EOF {
+		return nil
+	}
+	return s.err
+}
@@ -0,0 +1,207 @@
+package parse
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/easygf/core/log"
+)
+
+const testYear = 2026
+
+type roundTrip struct {
+	name string
+	in   log.Record
+	// what the layout keeps of in
+	want  log.Record
+	color bool
+	audit bool
+}
+
+func baseRecord() log.Record {
+	return log.Record{
+		Time:   time.Date(testYear, 3, 4, 5, 6, 7, 123400000, time.Local),
+		Level:  log.InfoLevel,
+		Module: "svc",
+		Pid:    12,
+		Gid:    34,
+		Pkg:    "github.com/easygf/core/log",
+		File:   "log.go",
+		Line:   56,
+		Func:   "main.run",
+		Msg:    "hello world",
+	}
+}
+
+func roundTrips() []roundTrip {
+	var cases []roundTrip
+	add := func(name string, color, audit bool, edit func(in, want *log.Record)) {
+		in, want := baseRecord(), baseRecord()
+		edit(&in, &want)
+		cases = append(cases, roundTrip{name: name, in: in, want: want, color: color, audit: audit})
+	}
+	add("plain", false, false, func(in, want *log.Record) {})
+	add("sub 100µs time", false, false, func(in, want *log.Record) {
+		in.Time = in.Time.Add(99 * time.Microsecond)
+	})
+	add("ctx", false, false, func(in, want *log.Record) {
+		in.Ctx, want.Ctx = "req-1", "req-1"
+	})
+	add("trace", false, false, func(in, want *log.Record) {
+		in.TraceID, want.TraceID = "0af7651916cd43dd8448eb211c80319c", "0af7651916cd43dd8448eb211c80319c"
+		in.SpanID, want.SpanID = "b7ad6b7169203331", "b7ad6b7169203331"
+	})
+	add("fields", false, false, func(in, want *log.Record) {
+		in.Fields = []log.Field{log.F("uid", 42), log.F("name", "bob smith")}
+		want.Msg = `hello world uid=42 name="bob smith"`
+	})
+	add("fields and trace", false, false, func(in, want *log.Record) {
+		in.Fields = []log.Field{log.F("uid", 42)}
+		in.TraceID, want.TraceID = "0af7651916cd43dd8448eb211c80319c", "0af7651916cd43dd8448eb211c80319c"
+		in.SpanID, want.SpanID = "b7ad6b7169203331", "b7ad6b7169203331"
+		want.Msg = "hello world uid=42"
+	})
+	add("no package", false, false, func(in, want *log.Record) {
+		in.Pkg, want.Pkg = "", ""
+	})
+	add("dpanic reads as panic", false, false, func(in, want *log.Record) {
+		in.Level, want.Level = log.DPanicLevel, log.PanicLevel
+	})
+	add("important", false, false, func(in, want *log.Record) {
+		in.Level, want.Level = log.ImportantLevel, log.ImportantLevel
+	})
+	add("color", true, false, func(in, want *log.Record) {
+		in.Level, want.Level = log.WarnLevel, log.WarnLevel
+		in.Ctx, want.Ctx = "req-2", "req-2"
+	})
+	add("multi-line", false, false, func(in, want *log.Record) {
+		in.Msg, want.Msg = "first\nsecond\n  third", "first\nsecond\n  third"
+	})
+	add("multi-line with trace", true, false, func(in, want *log.Record) {
+		in.Msg, want.Msg = "first\nsecond", "first\nsecond"
+		in.TraceID, want.TraceID = "0af7651916cd43dd8448eb211c80319c", "0af7651916cd43dd8448eb211c80319c"
+		in.SpanID, want.SpanID = "b7ad6b7169203331", "b7ad6b7169203331"
+	})
+	add("audit", false, true, func(in, want *log.Record) {
+		in.Fields = []log.Field{log.F("k", "v")}
+		want.Msg = "hello world k=v"
+	})
+	add("multi-line audit", false, true, func(in, want *log.Record) {
+		in.Msg, want.Msg = "first\nsecond", "first\nsecond"
+	})
+	return cases
+}
+
+// addAudit appends a hash to every line like log.FileLogger.EnableAudit
+func addAudit(s string) string {
+	lines := strings.SplitAfter(s, "\n")
+	var b strings.Builder
+	for _, l := range lines {
+		if l == "" {
+			continue
+		}
+		b.WriteString(strings.TrimSuffix(l, "\n"))
+		b.WriteString(" #h=")
+		b.WriteString(strings.Repeat("ab", 32))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func encode(c roundTrip) string {
+	var b bytes.Buffer
+	e := &log.TextEncoder{Color: c.color}
+	r := c.in
+	e.Encode(&b, &r)
+	if c.audit {
+		return addAudit(b.String())
+	}
+	return b.String()
+}
+
+func TestLineRoundTrip(t *testing.T) {
+	for _, c := range roundTrips() {
+		text := encode(c)
+		first := strings.SplitN(text, "\n", 2)[0]
+		got, ok := Line(first, testYear)
+		if !ok {
+			t.Errorf("%s: %q not parsed", c.name, first)
+			continue
+		}
+		want := c.want
+		if i := strings.IndexByte(want.Msg, '\n'); i >= 0 {
+			// Line only sees the first line, the trace ids follow the last one
+			want.Msg = want.Msg[:i]
+			want.TraceID, want.SpanID = "", ""
+		}
+		if !reflect.DeepEqual(*got, want) {
+			t.Errorf("%s: %q\n got %+v\nwant %+v", c.name, first, *got, want)
+		}
+	}
+}
+
+func TestScannerRoundTrip(t *testing.T) {
+	cases := roundTrips()
+	var b strings.Builder
+	b.WriteString("garbage before the first record\n")
+	for _, c := range cases {
+		if c.audit {
+			// each audit file starts with one, it follows the record before
+			b.WriteString("#audit-start - #h=" + strings.Repeat("ef", 32) + "\n")
+		}
+		b.WriteString(encode(c))
+		if c.audit {
+			b.WriteString("#audit-seal #h=" + strings.Repeat("cd", 32) + "\n")
+		}
+	}
+	s := NewScanner(strings.NewReader(b.String()), testYear)
+	i := 0
+	for s.Scan() {
+		if i >= len(cases) {
+			t.Fatalf("extra record %+v", *s.Record())
+		}
+		if got := *s.Record(); !reflect.DeepEqual(got, cases[i].want) {
+			t.Errorf("%s:\n got %+v\nwant %+v", cases[i].name, got, cases[i].want)
+		}
+		i++
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if i != len(cases) {
+		t.Errorf("got %d records, want %d", i, len(cases))
+	}
+}
+
+func TestScannerRaw(t *testing.T) {
+	c := roundTrips()[0]
+	c.in.Msg = "a\nb"
+	text := encode(c)
+	s := NewScanner(strings.NewReader(text), testYear)
+	if !s.Scan() {
+		t.Fatal("no record")
+	}
+	if got := strings.Join(s.Raw(), "\n") + "\n"; got != text {
+		t.Errorf("raw %q, want %q", got, text)
+	}
+}
+
+func TestStripColor(t *testing.T) {
+	if got := StripColor("\x1b[31mERR \x1b[0m x"); got != "ERR  x" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for s, want := range map[string]log.Level{"WAR": log.WarnLevel, "inf": log.InfoLevel, "error": log.ErrorLevel} {
+		if l, err := ParseLevel(s); err != nil || l != want {
+			t.Errorf("%s: %v %v", s, l, err)
+		}
+	}
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Error("loud parsed")
+	}
+}
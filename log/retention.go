@@ -0,0 +1,218 @@
+package log
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultRetentionInterval = 10 * time.Minute
+
+type RetentionPolicy struct {
+	// files last written longer ago are deleted, 0 keeps them forever
+	MaxAge time.Duration
+	// once the files of a logger exceed this many bytes the oldest are deleted, 0 means no budget
+	MaxTotalBytes int64
+	// gzip files as soon as they are rotated out
+	Compress bool
+	// how often the directory is swept, 0 means defaultRetentionInterval
+	Interval time.Duration
+	// called in the background with the final path, .gz when compressed,
+	// of every file that is no longer written, e.g. to upload or archive it
+	PostRotate func(path string)
+}
+
+// RetentionManager deletes and compresses the hourly files of one FileLogger
+type RetentionManager struct {
+	dir     string
+	pattern *regexp.Regexp
+	policy  RetentionPolicy
+	active  func() string
+	rotated chan string
+	stop    chan bool
+}
+
+// NewRetentionManager manages the files of logger name in dir, active returns the
+// file currently written which is never touched
+func NewRetentionManager(dir, name string, p RetentionPolicy, active func() string) *RetentionManager {
+	if p.Interval <= 0 {
+		p.Interval = defaultRetentionInterval
+	}
+	if active == nil {
+		active = func() string { return "" }
+	}
+	return &RetentionManager{
+		dir:     dir,
+		pattern: regexp.MustCompile(`^` + regexp.QuoteMeta(name) + `(\d{10})(\.\d+)?\.log(\.gz)?$`),
+		policy:  p,
+		active:  active,
+		rotated: make(chan string, 1000),
+		stop:    make(chan bool, 1),
+	}
+}
+
+func (m *RetentionManager) Start() {
+	go m.loop()
+}
+
+func (m *RetentionManager) Stop() {
+	select {
+	case m.stop <- true:
+	default:
+	}
+}
+
+// Rotated tells the manager path will not be written anymore
+func (m *RetentionManager) Rotated(path string) {
+	select {
+	case m.rotated <- path:
+	default:
+		// the next sweep picks it up
+	}
+}
+
+func (m *RetentionManager) loop() {
+	m.Sweep()
+	t := time.NewTicker(m.policy.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case p := <-m.rotated:
+			m.finish(p)
+		case <-t.C:
+			m.Sweep()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+func (m *RetentionManager) finish(path string) {
+	if m.policy.Compress && !strings.HasSuffix(path, ".gz") {
+		gz, err := gzipFile(path)
+		if os.IsNotExist(err) {
+			// already handled by a sweep
+			return
+		}
+		if err != nil {
+			fmt.Printf("compress log fail, path %s, err %s\n", path, err)
+			return
+		}
+		path = gz
+	}
+	if m.policy.PostRotate != nil {
+		m.policy.PostRotate(path)
+	}
+}
+
+type logFileInfo struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// Sweep compresses files left uncompressed and deletes those beyond the age and size limits.
+// Uncompressed files of the current hour are left alone, the logger may not
+// have opened its file yet or another process may append to them
+func (m *RetentionManager) Sweep() {
+	entries, err := os.ReadDir(m.dir)
+	if err != nil {
+		fmt.Printf("read log dir fail, dir %s, err %s\n", m.dir, err)
+		return
+	}
+	active := m.active()
+	hour := time.Now().Format("2006010215")
+	var files []logFileInfo
+	for _, e := range entries {
+		sub := m.pattern.FindStringSubmatch(e.Name())
+		if e.IsDir() || sub == nil {
+			continue
+		}
+		p := filepath.Join(m.dir, e.Name())
+		if p == filepath.Clean(active) || sub[1] == hour && sub[3] == "" {
+			continue
+		}
+		if m.policy.Compress && !strings.HasSuffix(p, ".gz") {
+			m.finish(p)
+			p += ".gz"
+		}
+		st, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		files = append(files, logFileInfo{path: p, size: st.Size(), modTime: st.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+	now := time.Now()
+	for _, f := range files {
+		expired := m.policy.MaxAge > 0 && now.Sub(f.modTime) > m.policy.MaxAge
+		overBudget := m.policy.MaxTotalBytes > 0 && total > m.policy.MaxTotalBytes
+		if !expired && !overBudget {
+			break
+		}
+		err := os.Remove(f.path)
+		if err != nil && !os.IsNotExist(err) {
+			fmt.Printf("remove log fail, path %s, err %s\n", f.path, err)
+			continue
+		}
+		total -= f.size
+	}
+}
+
+// gzipFile replaces path with path.gz, keeping its modification time
+func gzipFile(path string) (string, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = src.Close()
+	}()
+	st, err := src.Stat()
+	if err != nil {
+		return "", err
+	}
+	dst := path + ".gz"
+	tmp := dst + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, st.Mode().Perm())
+	if err != nil {
+		return "", err
+	}
+	w := gzip.NewWriter(f)
+	_, err = io.Copy(w, src)
+	if err == nil {
+		err = w.Close()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return "", err
+	}
+	_ = os.Chtimes(dst, st.ModTime(), st.ModTime())
+	err = os.Remove(path)
+	if err != nil {
+		return "", err
+	}
+	return dst, nil
+}
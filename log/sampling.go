@@ -0,0 +1,167 @@
+package log
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultSamplingInterval = time.Second
+
+// SamplingReportInterval is how often the lines suppressed by sampling are summarised
+var SamplingReportInterval = 10 * time.Second
+
+// SamplingPolicy limits the lines of one call site: the first First lines of
+// every Interval are logged, after that only every Thereafter-th one
+type SamplingPolicy struct {
+	First int
+	// 0 drops every line past First until the interval ends
+	Thereafter int
+	// 0 means defaultSamplingInterval
+	Interval time.Duration
+}
+
+type samplingSite struct {
+	mu         sync.Mutex
+	level      Level
+	start      time.Time
+	n          int
+	suppressed uint64
+	// caller of the site, used for the summary line
+	pkg, file, fn string
+	line          int
+	// logger of the last line suppressed, the summary goes where its lines go
+	lg *Logger
+}
+
+// samplingKey tells apart the loggers with their own level or output, see
+// Logger.route, so each summary is written where the lines it counts went
+type samplingKey struct {
+	route  *loggerConf
+	caller string
+}
+
+// samplingPolicies is indexed by level, DPanic and above are never sampled
+var samplingPolicies [DPanicLevel - DebugLevel]atomic.Value // *SamplingPolicy
+var samplingSites sync.Map                                  // samplingKey -> *samplingSite
+var samplingReporter sync.Once
+
+// SetSampling sets the policy of level l, nil turns sampling off. Lines at
+// DPanic level and above are always logged.
+func SetSampling(l Level, p *SamplingPolicy) {
+	if l < DebugLevel || l >= DPanicLevel {
+		return
+	}
+	if p != nil {
+		x := *p
+		if x.Interval <= 0 {
+			x.Interval = defaultSamplingInterval
+		}
+		p = &x
+		samplingReporter.Do(func() {
+			go reportSampling()
+		})
+	}
+	samplingPolicies[l-DebugLevel].Store(p)
+}
+
+// GetSampling returns the policies in effect by level
+func GetSampling() map[Level]SamplingPolicy {
+	m := map[Level]SamplingPolicy{}
+	for l := DebugLevel; l < DPanicLevel; l++ {
+		if p := samplingPolicy(l); p != nil {
+			m[l] = *p
+		}
+	}
+	return m
+}
+
+func samplingPolicy(l Level) *SamplingPolicy {
+	if l < DebugLevel || l >= DPanicLevel {
+		return nil
+	}
+	p, _ := samplingPolicies[l-DebugLevel].Load().(*SamplingPolicy)
+	return p
+}
+
+// sampled reports whether r, logged by lg, passes the sampling policy of its level
+func sampled(lg *Logger, r *Record) bool {
+	p := samplingPolicy(r.Level)
+	if p == nil {
+		return true
+	}
+	key := samplingKey{route: lg.route(), caller: r.Level.ShortString() + r.Caller()}
+	v, ok := samplingSites.Load(key)
+	if !ok {
+		v, _ = samplingSites.LoadOrStore(key, &samplingSite{
+			level: r.Level,
+			pkg:   r.Pkg,
+			file:  r.File,
+			fn:    r.Func,
+			line:  r.Line,
+		})
+	}
+	s := v.(*samplingSite)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.Time.Sub(s.start) >= p.Interval {
+		s.start = r.Time
+		s.n = 0
+	}
+	s.n++
+	if s.n <= p.First {
+		return true
+	}
+	if p.Thereafter > 0 && (s.n-p.First)%p.Thereafter == 0 {
+		return true
+	}
+	s.suppressed++
+	s.lg = lg
+	if st := statLevel(r.Level); st != nil {
+		atomic.AddUint64(&st.Suppressed, 1)
+	}
+	return false
+}
+
+func reportSampling() {
+	for {
+		time.Sleep(SamplingReportInterval)
+		now := time.Now()
+		samplingSites.Range(func(k, v interface{}) bool {
+			s := v.(*samplingSite)
+			s.mu.Lock()
+			n := s.suppressed
+			s.suppressed = 0
+			lg := s.lg
+			idle := now.Sub(s.start) > SamplingReportInterval
+			s.mu.Unlock()
+			if n > 0 {
+				r := &Record{
+					Time:   now,
+					Level:  s.level,
+					Module: modName,
+					Pid:    pid,
+					Pkg:    s.pkg,
+					File:   s.file,
+					Line:   s.line,
+					Func:   s.fn,
+					Fields: lg.recordFields(nil),
+				}
+				r.Msg = fmt.Sprintf("suppressed %d similar messages from %s", n, r.Caller())
+				if lg.conf != nil && lg.conf.module != "" {
+					r.Module = lg.conf.module
+				}
+				if c := lg.route(); c != nil {
+					c.output(r)
+				} else {
+					srcLevel, _ := sourceLevel(r)
+					outputRecord(r, r.Level >= srcLevel)
+				}
+			} else if idle {
+				samplingSites.Delete(k)
+			}
+			return true
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package log
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultSinkBuffer = 10000
+
+// LevelWriter is implemented by outputs which need the level of a line, e.g. syslog
+type LevelWriter interface {
+	WriteLevel(l Level, buf string) error
+}
+
+// Sink receives every line at or above Level, encoded with its own Encoder,
+// the text layout by default, coloured when Out is a terminal. With Records
+// set Out gets the records themselves and Encoder is unused.
+// Lines are queued and written by a goroutine of the sink so a slow output
+// never holds up the caller, they are dropped when the queue is full.
+type Sink struct {
+	// accessed atomically, kept first for 64-bit alignment
+	dropped uint64
+
+	Name    string
+	Level   Level
+	Encoder Encoder
+	Out     ILogger
+	// hand the records to Out, which must implement RecordWriter
+	Records bool
+	// queue length, 0 means defaultSinkBuffer
+	Buffer int
+
+	queue chan sinkItem
+}
+
+type sinkItem struct {
+	r    *Record
+	done chan bool
+	stop bool
+}
+
+var sinks atomic.Value // []*Sink
+var sinksMu sync.Mutex
+
+// minSinkLevel is the lowest level any sink wants, accessed atomically
+var minSinkLevel = int32(ImportantLevel + 1)
+
+func getSinks() []*Sink {
+	s, _ := sinks.Load().([]*Sink)
+	return s
+}
+
+func updateSinks(list []*Sink) {
+	sinks.Store(list)
+	min := ImportantLevel + 1
+	for _, s := range list {
+		if s.Level < min {
+			min = s.Level
+		}
+	}
+	atomic.StoreInt32(&minSinkLevel, int32(min))
+}
+
+// levelEnabled is the fast check done before formatting, it reports whether a
+// line at l may reach the main logger, a sink or a package level override
+func levelEnabled(l Level) bool {
+	return l >= getLevel() ||
+		int32(l) >= atomic.LoadInt32(&minSinkLevel) ||
+		int32(l) >= atomic.LoadInt32(&minOverrideLevel)
+}
+
+// AddSink registers s and starts its writer goroutine, names must be unique
+func AddSink(s *Sink) error {
+	if s.Name == "" || s.Out == nil {
+		return errors.New("sink needs a name and an output")
+	}
+	if _, ok := s.Out.(RecordWriter); s.Records && !ok {
+		return errors.New("sink " + s.Name + " output takes no records")
+	}
+	if s.Encoder == nil {
+		s.Encoder = textEncoder(outputColor(s.Out))
+	}
+	if s.Buffer <= 0 {
+		s.Buffer = defaultSinkBuffer
+	}
+	sinksMu.Lock()
+	defer sinksMu.Unlock()
+	old := getSinks()
+	for _, x := range old {
+		if x.Name == s.Name {
+			return errors.New("sink " + s.Name + " exists")
+		}
+	}
+	s.queue = make(chan sinkItem, s.Buffer)
+	go s.loop()
+	list := make([]*Sink, 0, len(old)+1)
+	list = append(list, old...)
+	updateSinks(append(list, s))
+	return nil
+}
+
+// RemoveSink unregisters the sink called name, flushes and returns it, nil if not found
+func RemoveSink(name string) *Sink {
+	sinksMu.Lock()
+	old := getSinks()
+	var found *Sink
+	list := make([]*Sink, 0, len(old))
+	for _, x := range old {
+		if x.Name == name {
+			found = x
+		} else {
+			list = append(list, x)
+		}
+	}
+	if found != nil {
+		updateSinks(list)
+	}
+	sinksMu.Unlock()
+	if found != nil {
+		found.Flush()
+		// the queue is never closed, a concurrent logIt may still hold the old list
+		select {
+		case found.queue <- sinkItem{stop: true}:
+		case <-time.After(time.Second):
+		}
+	}
+	return found
+}
+
+func GetSinks() []*Sink {
+	return getSinks()
+}
+
+func (s *Sink) loop() {
+	var b bytes.Buffer
+	for item := range s.queue {
+		if item.r != nil {
+			s.write(&b, item.r)
+		}
+		if item.done != nil {
+			close(item.done)
+		}
+		if item.stop {
+			return
+		}
+	}
+}
+
+func (s *Sink) write(b *bytes.Buffer, r *Record) {
+	if s.Records {
+		_ = s.Out.(RecordWriter).WriteRecord(r)
+		return
+	}
+	b.Reset()
+	s.Encoder.Encode(b, r)
+	if lw, ok := s.Out.(LevelWriter); ok {
+		_ = lw.WriteLevel(r.Level, b.String())
+	} else {
+		_ = s.Out.Write(b.String())
+	}
+}
+
+func (s *Sink) enqueue(r *Record) error {
+	select {
+	case s.queue <- sinkItem{r: r}:
+		return nil
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+		return errors.New("sink queue full")
+	}
+}
+
+// Dropped returns the number of lines lost because the queue was full
+func (s *Sink) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Flush waits up to a second for the queued lines to be written, then flushes the output
+func (s *Sink) Flush() {
+	done := make(chan bool)
+	select {
+	case s.queue <- sinkItem{done: done}:
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+		}
+	default:
+	}
+	s.Out.Flush()
+}
+
+func dispatchSinks(r *Record) {
+	for _, s := range getSinks() {
+		if r.Level >= s.Level {
+			_ = s.enqueue(r)
+		}
+	}
+}
+
+func flushSinks() {
+	for _, s := range getSinks() {
+		s.Flush()
+	}
+}
@@ -0,0 +1,109 @@
+package log
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+type syncer interface {
+	Sync() error
+}
+
+// WriterLogger adapts any io.Writer to ILogger, writes are synchronous so
+// it is meant to be the output of a Sink
+type WriterLogger struct {
+	w  io.Writer
+	mu sync.Mutex
+}
+
+func NewWriterLogger(w io.Writer) *WriterLogger {
+	return &WriterLogger{w: w}
+}
+
+func (l *WriterLogger) Write(buf string) error {
+	l.mu.Lock()
+	_, err := io.WriteString(l.w, buf)
+	l.mu.Unlock()
+	return err
+}
+
+func (l *WriterLogger) Sync() error {
+	if s, ok := l.w.(syncer); ok {
+		return s.Sync()
+	}
+	return nil
+}
+
+func (l *WriterLogger) Flush() {
+	_ = l.Sync()
+}
+
+func Stdout() ILogger {
+	return NewWriterLogger(os.Stdout)
+}
+
+func Stderr() ILogger {
+	return NewWriterLogger(os.Stderr)
+}
+
+const netRedialInterval = time.Second
+const netWriteTimeout = time.Second
+
+// NetLogger sends lines over udp or tcp, the connection is dialed lazily and
+// redialed after an error, lines written while it is down are lost
+type NetLogger struct {
+	network  string
+	addr     string
+	mu       sync.Mutex
+	conn     net.Conn
+	lastDial time.Time
+}
+
+func NewNetLogger(network, addr string) *NetLogger {
+	return &NetLogger{network: network, addr: addr}
+}
+
+func (l *NetLogger) Write(buf string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conn == nil {
+		if time.Since(l.lastDial) < netRedialInterval {
+			return errors.New("connection down")
+		}
+		l.lastDial = time.Now()
+		conn, err := net.DialTimeout(l.network, l.addr, netWriteTimeout)
+		if err != nil {
+			return err
+		}
+		l.conn = conn
+	}
+	_ = l.conn.SetWriteDeadline(time.Now().Add(netWriteTimeout))
+	_, err := io.WriteString(l.conn, buf)
+	if err != nil {
+		_ = l.conn.Close()
+		l.conn = nil
+	}
+	return err
+}
+
+func (l *NetLogger) Sync() error {
+	return nil
+}
+
+func (l *NetLogger) Flush() {
+}
+
+func (l *NetLogger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conn == nil {
+		return nil
+	}
+	err := l.conn.Close()
+	l.conn = nil
+	return err
+}
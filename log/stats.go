@@ -0,0 +1,70 @@
+package log
+
+import "sync/atomic"
+
+type LevelStats struct {
+	Written uint64
+	Dropped uint64
+	// lines discarded by sampling, see SetSampling
+	Suppressed uint64
+}
+
+type FileStats struct {
+	BytesWritten int64
+	FileSize     int64
+	// lines lost because the buffer or the last segment of the hour was
+	// full, see SetBackpressure and SetMaxSegments
+	DroppedLines uint64
+	DroppedBytes uint64
+}
+
+var levelStats [ImportantLevel - DebugLevel + 1]LevelStats
+
+func statLevel(l Level) *LevelStats {
+	if l < DebugLevel || l > ImportantLevel {
+		return nil
+	}
+	return &levelStats[l-DebugLevel]
+}
+
+func countLine(l Level, err error) {
+	s := statLevel(l)
+	if s == nil {
+		return
+	}
+	if err != nil {
+		atomic.AddUint64(&s.Dropped, 1)
+	} else {
+		atomic.AddUint64(&s.Written, 1)
+	}
+}
+
+// GetLevelStats returns the number of lines accepted, dropped and suppressed at level l since start
+func GetLevelStats(l Level) LevelStats {
+	s := statLevel(l)
+	if s == nil {
+		return LevelStats{}
+	}
+	return LevelStats{
+		Written:    atomic.LoadUint64(&s.Written),
+		Dropped:    atomic.LoadUint64(&s.Dropped),
+		Suppressed: atomic.LoadUint64(&s.Suppressed),
+	}
+}
+
+func (l *FileLogger) Stats() FileStats {
+	return FileStats{
+		BytesWritten: atomic.LoadInt64(&l.bytesWritten),
+		FileSize:     atomic.LoadInt64(&l.fileSize),
+		DroppedLines: atomic.LoadUint64(&l.droppedLines),
+		DroppedBytes: atomic.LoadUint64(&l.droppedBytes),
+	}
+}
+
+// GetFileStats returns the stats of the main logger, zero if it does not write to a file
+func GetFileStats() FileStats {
+	if l, ok := logger.(*FileLogger); ok {
+		return l.Stats()
+	}
+	return FileStats{}
+}
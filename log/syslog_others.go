@@ -0,0 +1,26 @@
+//go:build windows || plan9
+
+package log
+
+import "errors"
+
+type SyslogLogger struct{}
+
+func NewSyslogLogger(network, raddr, tag string) (*SyslogLogger, error) {
+	return nil, errors.New("syslog not supported")
+}
+
+func (l *SyslogLogger) Write(buf string) error {
+	return errors.New("syslog not supported")
+}
+
+func (l *SyslogLogger) Sync() error {
+	return nil
+}
+
+func (l *SyslogLogger) Flush() {
+}
+
+func (l *SyslogLogger) Close() error {
+	return nil
+}
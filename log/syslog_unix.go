@@ -0,0 +1,52 @@
+//go:build !windows && !plan9
+
+package log
+
+import (
+	"log/syslog"
+)
+
+// SyslogLogger writes to the local syslog daemon, or a remote one when network is set
+type SyslogLogger struct {
+	w *syslog.Writer
+}
+
+func NewSyslogLogger(network, raddr, tag string) (*SyslogLogger, error) {
+	w, err := syslog.Dial(network, raddr, syslog.LOG_INFO|syslog.LOG_USER, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogLogger{w: w}, nil
+}
+
+func (l *SyslogLogger) Write(buf string) error {
+	return l.w.Info(buf)
+}
+
+func (l *SyslogLogger) WriteLevel(lv Level, buf string) error {
+	switch lv {
+	case DebugLevel:
+		return l.w.Debug(buf)
+	case InfoLevel, ImportantLevel:
+		return l.w.Info(buf)
+	case WarnLevel:
+		return l.w.Warning(buf)
+	case ErrorLevel:
+		return l.w.Err(buf)
+	case FatalLevel:
+		return l.w.Crit(buf)
+	default:
+		return l.w.Alert(buf)
+	}
+}
+
+func (l *SyslogLogger) Sync() error {
+	return nil
+}
+
+func (l *SyslogLogger) Flush() {
+}
+
+func (l *SyslogLogger) Close() error {
+	return l.w.Close()
+}
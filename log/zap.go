@@ -0,0 +1,106 @@
+package log
+
+import (
+	"context"
+
+	"github.com/easygf/core/log/atexit"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// zap and zapcore
+var zapPrefixes = []string{"go.uber.org/zap"}
+
+type zapCore struct {
+	fields []Field
+}
+
+// NewZapCore returns a zap core logging here, levels map one to one
+func NewZapCore() zapcore.Core {
+	return &zapCore{}
+}
+
+// NewZapLogger returns a zap logger logging here with the caller of each line
+func NewZapLogger() *zap.Logger {
+	return zap.New(NewZapCore(), zap.AddCaller())
+}
+
+func fromZapLevel(l zapcore.Level) Level {
+	switch l {
+	case zapcore.DebugLevel:
+		return DebugLevel
+	case zapcore.InfoLevel:
+		return InfoLevel
+	case zapcore.WarnLevel:
+		return WarnLevel
+	case zapcore.ErrorLevel:
+		return ErrorLevel
+	case zapcore.DPanicLevel:
+		return DPanicLevel
+	case zapcore.PanicLevel:
+		return PanicLevel
+	case zapcore.FatalLevel:
+		return FatalLevel
+	}
+	if l < zapcore.DebugLevel {
+		return DebugLevel
+	}
+	return FatalLevel
+}
+
+// zapFields keeps the order of fs, nested values become maps
+func zapFields(fs []zapcore.Field) []Field {
+	out := make([]Field, 0, len(fs))
+	for _, f := range fs {
+		enc := zapcore.NewMapObjectEncoder()
+		f.AddTo(enc)
+		for k, v := range enc.Fields {
+			out = append(out, Field{Key: k, Val: v})
+		}
+	}
+	return out
+}
+
+func (c *zapCore) Enabled(l zapcore.Level) bool {
+	return Default().Enabled(fromZapLevel(l))
+}
+
+func (c *zapCore) With(fs []zapcore.Field) zapcore.Core {
+	return &zapCore{fields: joinFields(c.fields, zapFields(fs))}
+}
+
+func (c *zapCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+// Write logs ent, panics and exits are left to zap which follows up on its own
+func (c *zapCore) Write(ent zapcore.Entry, fs []zapcore.Field) error {
+	var opt *Optimization
+	if ent.Caller.Defined && ent.Caller.Function != "" {
+		opt = &Optimization{ShortFile: ent.Caller.File, CallerName: ent.Caller.Function, CallerLine: ent.Caller.Line}
+	} else {
+		opt = externalCaller(zapPrefixes...)
+	}
+	fields := joinFields(c.fields, zapFields(fs))
+	if ent.LoggerName != "" {
+		fields = joinFields([]Field{F("logger", ent.LoggerName)}, fields)
+	}
+	logIt(Default(), nil, opt, fromZapLevel(ent.Level), fields, ent.Message)
+	switch ent.Level {
+	case zapcore.FatalLevel:
+		// zap calls os.Exit next
+		atexit.Shutdown()
+	case zapcore.PanicLevel, zapcore.DPanicLevel:
+		return c.Sync()
+	}
+	return nil
+}
+
+func (c *zapCore) Sync() error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultFlushTimeout)
+	defer cancel()
+	return FlushCtx(ctx)
+}
@@ -0,0 +1,151 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/easygf/core/log/atexit"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// zapSinkScheme is the zap.RegisterSink scheme of NewZapConfig, the json
+// entries written to it are decoded back into records
+const zapSinkScheme = "easygf-log"
+
+// keys of the json layout of NewZapConfig, other keys are fields
+const (
+	zapMsgKey    = "msg"
+	zapLevelKey  = "level"
+	zapNameKey   = "logger"
+	zapCallerKey = "caller"
+	zapFuncKey   = "func"
+)
+
+var registerZapSink sync.Once
+
+// NewZapConfig returns a zap config logging here, for code building its zap
+// logger from a config only, like the LogConfig of the etcd client. The
+// levels are left to this package, NewZapCore is cheaper where a core can
+// be passed.
+func NewZapConfig() *zap.Config {
+	registerZapSink.Do(func() {
+		err := zap.RegisterSink(zapSinkScheme, func(*url.URL) (zap.Sink, error) {
+			return zapSink{}, nil
+		})
+		if err != nil {
+			Errorf("err:%v", err)
+		}
+	})
+	return &zap.Config{
+		Level:             zap.NewAtomicLevelAt(zapcore.DebugLevel),
+		DisableStacktrace: true,
+		Encoding:          "json",
+		EncoderConfig: zapcore.EncoderConfig{
+			MessageKey:     zapMsgKey,
+			LevelKey:       zapLevelKey,
+			NameKey:        zapNameKey,
+			CallerKey:      zapCallerKey,
+			FunctionKey:    zapFuncKey,
+			EncodeLevel:    zapcore.LowercaseLevelEncoder,
+			EncodeCaller:   zapcore.FullCallerEncoder,
+			EncodeDuration: zapcore.StringDurationEncoder,
+		},
+		OutputPaths:      []string{zapSinkScheme + ":"},
+		ErrorOutputPaths: []string{"stderr"},
+	}
+}
+
+type zapSink struct{}
+
+// Write gets one json entry per call
+func (zapSink) Write(p []byte) (int, error) {
+	for _, line := range bytes.Split(bytes.TrimSpace(p), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		err := logZapJSON(line)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (zapSink) Sync() error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultFlushTimeout)
+	defer cancel()
+	return FlushCtx(ctx)
+}
+
+func (zapSink) Close() error {
+	return nil
+}
+
+func logZapJSON(line []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if t != json.Delim('{') {
+		return errors.New("zap entry is not an object")
+	}
+	l := InfoLevel
+	var msg, name, caller, fn string
+	var fields []Field
+	for dec.More() {
+		t, err = dec.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := t.(string)
+		var v interface{}
+		err = dec.Decode(&v)
+		if err != nil {
+			return err
+		}
+		s, _ := v.(string)
+		switch key {
+		case zapMsgKey:
+			msg = s
+		case zapLevelKey:
+			var zl zapcore.Level
+			if zl.UnmarshalText([]byte(s)) == nil {
+				l = fromZapLevel(zl)
+			}
+		case zapNameKey:
+			name = s
+		case zapCallerKey:
+			caller = s
+		case zapFuncKey:
+			fn = s
+		default:
+			fields = append(fields, Field{Key: key, Val: v})
+		}
+	}
+	if l == FatalLevel {
+		// zap calls os.Exit next, logged or not
+		defer atexit.Shutdown()
+	}
+	if !Default().Enabled(l) {
+		return nil
+	}
+	if name != "" {
+		fields = joinFields([]Field{F("logger", name)}, fields)
+	}
+	opt := externalCaller(zapPrefixes...)
+	if i := strings.LastIndexByte(caller, ':'); i > 0 && fn != "" {
+		line, _ := strconv.Atoi(caller[i+1:])
+		opt = &Optimization{ShortFile: caller[:i], CallerName: fn, CallerLine: line}
+	}
+	logIt(Default(), nil, opt, l, fields, msg)
+	return nil
+}
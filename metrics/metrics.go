@@ -0,0 +1,188 @@
+// Package metrics exports etcd, config and log activity to Prometheus.
+// Nothing is collected until Register is called.
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/easygf/core/config"
+	"github.com/easygf/core/etcdutils"
+	"github.com/easygf/core/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "easygf"
+
+var logLevels = []log.Level{
+	log.DebugLevel, log.InfoLevel, log.WarnLevel, log.ErrorLevel,
+	log.DPanicLevel, log.PanicLevel, log.FatalLevel, log.ImportantLevel,
+}
+
+var registerOnce sync.Once
+
+func errLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// Register creates the collectors on reg and hooks them into etcdutils, config
+// and log, it may be called once config and etcd are in use, operations
+// running at that moment go unrecorded
+func Register(reg prometheus.Registerer) (err error) {
+	registerOnce.Do(func() {
+		err = register(reg)
+	})
+	return
+}
+
+func register(reg prometheus.Registerer) error {
+	etcdDuration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "etcd",
+		Name:      "op_duration_seconds",
+		Help:      "Latency of etcd operations including retries.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"op", "result"})
+	etcdErrors := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "etcd",
+		Name:      "op_errors_total",
+		Help:      "Failed etcd operations.",
+	}, []string{"op"})
+	etcdCircuitOpen := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "etcd",
+		Name:      "circuit_open",
+		Help:      "1 while the etcd circuit breaker is open.",
+	}, func() float64 {
+		if etcdutils.CircuitOpen() {
+			return 1
+		}
+		return 0
+	})
+
+	configDuration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "config",
+		Name:      "op_duration_seconds",
+		Help:      "Latency of config operations.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 16),
+	}, []string{"op", "result"})
+	configErrors := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "config",
+		Name:      "op_errors_total",
+		Help:      "Failed config operations.",
+	}, []string{"op"})
+	configReads := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "config",
+		Name:      "reads_total",
+		Help:      "Config reads by the source that served them, local_fs reads are cache hits.",
+	}, []string{"source"})
+	configVersion := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "config",
+		Name:      "version",
+		Help:      "Version of the value currently loaded by a JsonConfig, 0 once deleted.",
+	}, []string{"key"})
+	configUpdated := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "config",
+		Name:      "last_update_timestamp_seconds",
+		Help:      "Unix time a JsonConfig last loaded a new value.",
+	}, []string{"key"})
+
+	logLines := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "log", "lines_total"),
+		"Log lines accepted by the logger.", []string{"level"}, nil)
+	logDropped := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "log", "dropped_total"),
+		"Log lines dropped by the logger.", []string{"level"}, nil)
+	logSuppressed := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "log", "suppressed_total"),
+		"Log lines discarded by sampling.", []string{"level"}, nil)
+	logBytes := prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "log",
+		Name:      "bytes_written_total",
+		Help:      "Bytes written to log files.",
+	}, func() float64 {
+		return float64(log.GetFileStats().BytesWritten)
+	})
+	logDroppedBytes := prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "log",
+		Name:      "dropped_bytes_total",
+		Help:      "Bytes dropped because the log buffer was full.",
+	}, func() float64 {
+		return float64(log.GetFileStats().DroppedBytes)
+	})
+	logFileSize := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "log",
+		Name:      "file_size_bytes",
+		Help:      "Size of the log file currently written.",
+	}, func() float64 {
+		return float64(log.GetFileStats().FileSize)
+	})
+
+	for _, c := range []prometheus.Collector{
+		etcdDuration, etcdErrors, etcdCircuitOpen,
+		configDuration, configErrors, configReads, configVersion, configUpdated,
+		&levelCollector{lines: logLines, dropped: logDropped, suppressed: logSuppressed}, logBytes, logDroppedBytes, logFileSize,
+	} {
+		err := reg.Register(c)
+		if err != nil {
+			return err
+		}
+	}
+
+	etcdutils.SetObserver(func(op string, cost time.Duration, err error) {
+		etcdDuration.WithLabelValues(op, errLabel(err)).Observe(cost.Seconds())
+		if err != nil {
+			etcdErrors.WithLabelValues(op).Inc()
+		}
+	})
+	config.SetObserver(config.Observer{
+		Op: func(op string, cost time.Duration, err error) {
+			configDuration.WithLabelValues(op, errLabel(err)).Observe(cost.Seconds())
+			if err != nil {
+				configErrors.WithLabelValues(op).Inc()
+			}
+		},
+		Read: func(key string, source config.ItemSource) {
+			configReads.WithLabelValues(source.String()).Inc()
+		},
+		JsonConfig: func(key string, ver int64, updatedAt time.Time) {
+			configVersion.WithLabelValues(key).Set(float64(ver))
+			configUpdated.WithLabelValues(key).Set(float64(updatedAt.Unix()))
+		},
+	})
+	return nil
+}
+
+// levelCollector reads the per level counters kept by the log package
+type levelCollector struct {
+	lines      *prometheus.Desc
+	dropped    *prometheus.Desc
+	suppressed *prometheus.Desc
+}
+
+func (c *levelCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.lines
+	ch <- c.dropped
+	ch <- c.suppressed
+}
+
+func (c *levelCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, l := range logLevels {
+		s := log.GetLevelStats(l)
+		ch <- prometheus.MustNewConstMetric(c.lines, prometheus.CounterValue, float64(s.Written), l.String())
+		ch <- prometheus.MustNewConstMetric(c.dropped, prometheus.CounterValue, float64(s.Dropped), l.String())
+		ch <- prometheus.MustNewConstMetric(c.suppressed, prometheus.CounterValue, float64(s.Suppressed), l.String())
+	}
+}